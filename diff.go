@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// diff returns a unified diff between b1 and b2, labeled with filename, by
+// running the system diff command, as gofmt used to.
+func diff(b1, b2 []byte, filename string) (data []byte, err error) {
+	f1, err := writeTempFile("", "gofixunkeyedcomposites", b1)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f1)
+
+	f2, err := writeTempFile("", "gofixunkeyedcomposites", b2)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f2)
+
+	data, err = exec.Command("diff", "-u", f1, f2).CombinedOutput()
+	if len(data) > 0 {
+		// diff exits with a non-zero status when the files don't match.
+		// Ignore that failure as long as we get output.
+		return replaceTempFilename(data, filename), nil
+	}
+	return data, err
+}
+
+func writeTempFile(dir, prefix string, data []byte) (string, error) {
+	file, err := ioutil.TempFile(dir, prefix)
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Write(data)
+	if err1 := file.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// replaceTempFilename replaces the temporary file names in the diff header
+// lines with filename.orig and filename.
+func replaceTempFilename(diff []byte, filename string) []byte {
+	lines := bytes.SplitN(diff, []byte{'\n'}, 3)
+	if len(lines) < 3 {
+		return diff
+	}
+	for i, prefix := range []string{"--- ", "+++ "} {
+		name := filename
+		if i == 0 {
+			name += ".orig"
+		}
+		if bytes.HasPrefix(lines[i], []byte(prefix)) {
+			lines[i] = []byte(prefix + name)
+		}
+	}
+	return bytes.Join(lines, []byte{'\n'})
+}
+
+// gitHeaders replaces the header lines of a diff of filename made by diff with
+// those of git diff, so that git apply takes it.
+func gitHeaders(diff []byte, filename string) []byte {
+	lines := bytes.SplitN(diff, []byte{'\n'}, 3)
+	if len(lines) < 3 {
+		return diff
+	}
+	name := filepath.ToSlash(filepath.Clean(filename))
+	header := fmt.Sprintf("diff --git a/%s b/%s\n--- a/%s\n+++ b/%s\n", name, name, name, name)
+	return append([]byte(header), lines[2]...)
+}
+
+// gitPath returns the path to name the file at absPath by in git's headers:
+// relative to root if set, or to the current directory otherwise, which is
+// where git apply must be run from. Files outside it can't be named.
+func gitPath(root, absPath string) (string, error) {
+	if root == "" {
+		root = "."
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absRoot, absPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("outside %s, so git apply can't take its diff", absRoot)
+	}
+	return rel, nil
+}
+
+const (
+	colorReset = "\x1b[0m"
+	colorBold  = "\x1b[1m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorCyan  = "\x1b[36m"
+)
+
+// colorize adds terminal color codes to a unified diff: removals in red,
+// additions in green and hunk headers in cyan.
+func colorize(diff []byte) []byte {
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(diff, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		var color string
+		switch {
+		case bytes.HasPrefix(line, []byte("--- ")), bytes.HasPrefix(line, []byte("+++ ")):
+			color = colorBold
+		case bytes.HasPrefix(line, []byte("@@")):
+			color = colorCyan
+		case line[0] == '-':
+			color = colorRed
+		case line[0] == '+':
+			color = colorGreen
+		}
+		if color == "" {
+			out.Write(line)
+			continue
+		}
+		text := bytes.TrimSuffix(line, []byte{'\n'})
+		out.WriteString(color)
+		out.Write(text)
+		out.WriteString(colorReset)
+		out.Write(line[len(text):])
+	}
+	return out.Bytes()
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"go/build"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileSystem is the file system gofixunkeyedcomposites reads source files
+// from and writes fixed files and reports to. Fixed files are written with
+// ReplaceFile, which shouldn't leave them half written, and reports with
+// WriteFile.
+//
+// Unlike with plain fs.FS implementations, names are host paths, as given on
+// the command line or made absolute from them. EvalSymlinks resolves them like
+// filepath.EvalSymlinks, to tell when files are the same.
+type fileSystem interface {
+	fs.ReadFileFS
+	fs.ReadDirFS
+	fs.StatFS
+	EvalSymlinks(name string) (string, error)
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	ReplaceFile(name string, data []byte, perm fs.FileMode) error
+}
+
+// osFS is the fileSystem backed by the operating system.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) EvalSymlinks(name string) (string, error) {
+	return filepath.EvalSymlinks(name)
+}
+
+// WriteFile writes data to name, creating it with perm if needed. Files that
+// aren't regular ones, like pipes and devices, and those stdout or stderr go
+// to, as through /dev/stdout, are appended to instead of truncated, so that
+// what was written to them before is kept.
+func (osFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if fi, err := os.Stat(name); err == nil && isStream(fi) {
+		flag = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// isStream reports whether fi, of a file that exists, describes something
+// written to as a stream rather than a regular file.
+func isStream(fi fs.FileInfo) bool {
+	if !fi.Mode().IsRegular() {
+		return true
+	}
+	for _, f := range []*os.File{os.Stdout, os.Stderr} {
+		if std, err := f.Stat(); err == nil && os.SameFile(fi, std) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplaceFile writes data to a temporary file next to name, flushes it to
+// disk and renames it to name, so that name is never left half written, not
+// even by a crash, and the temporary file is removed if that fails. Existing
+// files keep their permissions, and are written through symlinks, but must be
+// writable. Streams, as for isStream, can't be replaced, so they're written
+// to with WriteFile.
+func (fsys osFS) ReplaceFile(name string, data []byte, perm fs.FileMode) error {
+	if real, err := filepath.EvalSymlinks(name); err == nil {
+		name = real
+		fi, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if isStream(fi) {
+			return fsys.WriteFile(name, data, perm)
+		}
+		perm = fi.Mode().Perm()
+		// Renaming over name would work even if it's read-only.
+		f, err := os.OpenFile(name, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		f.Close()
+	}
+
+	// Hidden, so that it's never taken for a Go file if left behind.
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// buildContext returns build.Default reading files from fsys.
+func buildContext(fsys fileSystem) *build.Context {
+	ctxt := build.Default
+	ctxt.OpenFile = func(path string) (io.ReadCloser, error) {
+		return fsys.Open(path)
+	}
+	ctxt.IsDir = func(path string) bool {
+		fi, err := fsys.Stat(path)
+		return err == nil && fi.IsDir()
+	}
+	ctxt.ReadDir = func(dir string) ([]fs.FileInfo, error) {
+		entries, err := fsys.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		infos := make([]fs.FileInfo, 0, len(entries))
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, info)
+		}
+		return infos, nil
+	}
+	return &ctxt
+}
+
+func isGoFile(d fs.DirEntry) bool {
+	name := d.Name()
+	return !d.IsDir() && !strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".go")
+}
+
+// ignoredDir reports whether the go command ignores directories named name
+// when matching packages.
+func ignoredDir(name string) bool {
+	return name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+// walkGoFiles calls fn for path if it is a file, or for every Go file below
+// path if it is a directory, much like gofmt does. Directories below path that
+// the go command ignores in patterns like ./..., vendor and testdata ones and
+// those starting with . or _, aren't walked, and other files and directories
+// found below path are skipped if skip, when not nil, reports so.
+func walkGoFiles(fsys fileSystem, path string, skip func(path string, isDir bool) (bool, error), fn func(path string) error) error {
+	fi, err := fsys.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fn(path)
+	}
+
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		p := filepath.Join(path, e.Name())
+		if e.IsDir() && ignoredDir(e.Name()) {
+			continue
+		}
+		if skip != nil && (e.IsDir() || isGoFile(e)) {
+			skipped, err := skip(p, e.IsDir())
+			if err != nil {
+				return err
+			}
+			if skipped {
+				continue
+			}
+		}
+		if e.IsDir() {
+			err = walkGoFiles(fsys, p, skip, fn)
+		} else if isGoFile(e) {
+			err = fn(p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// TestWriteFileError makes writing the temporary file fail by limiting the
+// size of files, which even root can't write past, and checks that the file
+// is left as it was and the temporary file removed.
+func TestWriteFileError(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.go")
+	writeFile(t, name, "package a\n")
+
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	small := limit
+	small.Cur = 16
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &small); err != nil {
+		t.Skip("can't limit file sizes:", err)
+	}
+	err := osFS{}.ReplaceFile(name, []byte("package a\n\nvar x = 1234567890\n"), 0644)
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	if err == nil {
+		t.Fatal("writing past the file size limit succeeded")
+	}
+
+	if got := readFile(t, name); got != "package a\n" {
+		t.Errorf("file changed to:\n%s", got)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		for _, e := range entries {
+			t.Errorf("left behind: %s", e.Name())
+		}
+	}
+}
+
+// TestWriteReportStream writes the summary to a named pipe, which must be
+// written to rather than replaced with a regular file.
+func TestWriteReportStream(t *testing.T) {
+	dir := t.TempDir()
+	fifo := filepath.Join(dir, "summary")
+	if err := syscall.Mkfifo(fifo, 0600); err != nil {
+		t.Skip("can't make a named pipe:", err)
+	}
+	writeFile(t, filepath.Join(dir, "a.go"), "package a\n")
+
+	read := make(chan string)
+	go func() {
+		var data []byte
+		if f, err := os.Open(fifo); err == nil {
+			data, _ = io.ReadAll(f)
+			f.Close()
+		}
+		read <- string(data)
+	}()
+	stdout, stderr, code := run(t, "", "-l", "-summary-json", fifo, filepath.Join(dir, "a.go"))
+	if code != 0 || stdout != "" || stderr != "" {
+		t.Fatalf("exit status %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+	var summary runSummary
+	if got := <-read; json.Unmarshal([]byte(got), &summary) != nil || summary.FilesScanned != 1 {
+		t.Errorf("read from the pipe:\n%s", got)
+	}
+	if fi, err := os.Lstat(fifo); err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("pipe replaced: %v, %v", fi, err)
+	}
+}
+
+// TestWriteReportStdout writes the summary to the file stdout goes to, as
+// with -summary-json /dev/stdout, which must keep what was printed before.
+func TestWriteReportStdout(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.go"), "package a\n\ntype T struct{ A, B int }\n\nvar t = T{1, 2}\n")
+	out, err := os.Create(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	saved := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = saved }()
+
+	var errOut strings.Builder
+	code := Run([]string{"-l", "-summary-json", out.Name(), filepath.Join(dir, "a.go")}, strings.NewReader(""), out, &errOut)
+	if code != 0 || errOut.Len() != 0 {
+		t.Fatalf("exit status %d, stderr:\n%s", code, errOut.String())
+	}
+	got := readFile(t, out.Name())
+	listed := filepath.Join(dir, "a.go") + "\n"
+	var summary runSummary
+	if !strings.HasPrefix(got, listed) || json.Unmarshal([]byte(strings.TrimPrefix(got, listed)), &summary) != nil || summary.FilesScanned != 1 {
+		t.Errorf("stdout:\n%s", got)
+	}
+}
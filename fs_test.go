@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"go/build"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// memFS is a fileSystem in memory, whose host paths are those of the MapFS
+// without the leading separator.
+type memFS struct{ fstest.MapFS }
+
+func memName(name string) string {
+	name = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(name)), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func (m memFS) Open(name string) (fs.File, error) {
+	return m.MapFS.Open(memName(name))
+}
+
+func (m memFS) ReadFile(name string) ([]byte, error) {
+	return m.MapFS.ReadFile(memName(name))
+}
+
+func (m memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return m.MapFS.ReadDir(memName(name))
+}
+
+func (m memFS) Stat(name string) (fs.FileInfo, error) {
+	return m.MapFS.Stat(memName(name))
+}
+
+func (m memFS) EvalSymlinks(name string) (string, error) {
+	if _, err := m.Stat(name); err != nil {
+		return "", err
+	}
+	return filepath.Clean(name), nil
+}
+
+func (m memFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	m.MapFS[memName(name)] = &fstest.MapFile{Data: data, Mode: perm}
+	return nil
+}
+
+func (m memFS) ReplaceFile(name string, data []byte, perm fs.FileMode) error {
+	return m.WriteFile(name, data, perm)
+}
+
+// TestMemFS fixes files that only exist in memory, which must be found,
+// along with their modules, GOPATH, siblings, dependencies and ignore files,
+// without looking at the disk.
+func TestMemFS(t *testing.T) {
+	moduleEnv(t)
+	gopath := build.Default.GOPATH
+	build.Default.GOPATH = filepath.FromSlash("/gopath")
+	t.Cleanup(func() { build.Default.GOPATH = gopath })
+
+	fsys := memFS{fstest.MapFS{
+		"m/go.mod":                           {Data: []byte("module example.com/mem\n\ngo 1.21\n")},
+		"m/.gofixignore":                     {Data: []byte("gen.go\n")},
+		"m/a.go":                             {Data: []byte("package a\n\nvar t = T{1, 2}\n")},
+		"m/b.go":                             {Data: []byte("package a\n\ntype T struct{ A, B int }\n")},
+		"gopath/src/example.com/gp/g.go":     {Data: []byte("package gp\n\nimport \"example.com/gp/dep\"\n\nvar d = dep.D{1, 2}\n")},
+		"gopath/src/example.com/gp/dep/d.go": {Data: []byte("package dep\n\ntype D struct{ X, Y int }\n")},
+	}}
+	host := filepath.FromSlash
+
+	if got, want := importPath(fsys, host("/m/sub")), "example.com/mem/sub"; got != want {
+		t.Errorf("module import path %q, want %q", got, want)
+	}
+	if got, want := moduleGoVersion(fsys, host("/m/sub")), "go1.21"; got != want {
+		t.Errorf("module Go version %q, want %q", got, want)
+	}
+	if got, want := importPath(fsys, host("/gopath/src/example.com/gp")), "example.com/gp"; got != want {
+		t.Errorf("GOPATH import path %q, want %q", got, want)
+	}
+
+	ig := newIgnorer(fsys)
+	for path, want := range map[string]bool{"/m/gen.go": true, "/m/a.go": false} {
+		if got, err := ig.ignored(host(path), false); got != want || err != nil {
+			t.Errorf("%s ignored: %v, %v", path, got, err)
+		}
+	}
+
+	for _, tt := range []struct{ path, want, typ string }{
+		{"/m/a.go", "package a\n\nvar t = T{A: 1, B: 2}\n", "example.com/mem.T"},
+		{"/gopath/src/example.com/gp/g.go", "package gp\n\nimport \"example.com/gp/dep\"\n\nvar d = dep.D{X: 1, Y: 2}\n", "example.com/gp/dep.D"},
+	} {
+		var out bytes.Buffer
+		res, err := fixFile(fsys, &out, nil, host(tt.path), config{})
+		if err != nil {
+			t.Errorf("%s: %s", tt.path, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s:\n%s\nwant:\n%s", tt.path, out.String(), tt.want)
+		}
+		if len(res.lits) != 1 || res.lits[0].qualifiedTyp != tt.typ {
+			t.Errorf("%s: keyed %+v, want a %s literal", tt.path, res.lits, tt.typ)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// gitChangedFiles returns the Go files in the current directory and below
+// that differ between base and the working tree, including staged changes,
+// as reported by git. Deleted files are left out.
+func gitChangedFiles(base string) ([]string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "diff", "--name-only", "--relative", "--diff-filter=d", base, "--", "*.go")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git diff: %s", msg)
+		}
+		return nil, fmt.Errorf("git diff: %s", err)
+	}
+
+	var files []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// goListFiles returns the Go files of the packages described by r, a stream
+// of JSON objects like the output of go list -json.
+func goListFiles(r io.Reader) ([]string, error) {
+	var files []string
+	dec := json.NewDecoder(r)
+	for {
+		var pkg struct {
+			ImportPath string
+			Dir        string
+			GoFiles    []string
+			CgoFiles   []string
+		}
+		err := dec.Decode(&pkg)
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading go list output: %s", err)
+		}
+		if pkg.Dir == "" {
+			return nil, fmt.Errorf("reading go list output: package %q has no Dir", pkg.ImportPath)
+		}
+		for _, names := range [][]string{pkg.GoFiles, pkg.CgoFiles} {
+			for _, name := range names {
+				files = append(files, filepath.Join(pkg.Dir, name))
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFileName is the name of the file, at the root of a module, listing
+// paths to skip when walking directories, in gitignore syntax.
+const ignoreFileName = ".gofixignore"
+
+// An ignorer decides which paths found while walking directories are skipped
+// because of the .gofixignore file of their module.
+type ignorer struct {
+	fsys  fileSystem
+	rules map[string][]ignorePattern // by module root
+}
+
+func newIgnorer(fsys fileSystem) *ignorer {
+	return &ignorer{fsys: fsys, rules: map[string][]ignorePattern{}}
+}
+
+// ignored reports whether path, which is a directory if isDir, is matched by
+// the .gofixignore file at the root of the module containing it.
+func (ig *ignorer) ignored(path string, isDir bool) (bool, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	root, _, ok := findModule(ig.fsys, filepath.Dir(abs))
+	if !ok {
+		return false, nil
+	}
+	patterns, ok := ig.rules[root]
+	if !ok {
+		name := filepath.Join(root, ignoreFileName)
+		data, err := ig.fsys.ReadFile(name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return false, err
+		}
+		patterns, err = parseIgnoreFile(data)
+		if err != nil {
+			return false, fmt.Errorf("%s: %s", name, err)
+		}
+		ig.rules[root] = patterns
+	}
+
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return false, nil
+	}
+	rel = filepath.ToSlash(rel)
+	// Everything below an ignored directory is ignored too, even when the
+	// walk starts inside it.
+	elems := strings.Split(rel, "/")
+	for i := 1; i < len(elems); i++ {
+		if matchIgnore(patterns, strings.Join(elems[:i], "/"), true) {
+			return true, nil
+		}
+	}
+	return matchIgnore(patterns, rel, isDir), nil
+}
+
+func matchIgnore(patterns []ignorePattern, rel string, isDir bool) bool {
+	var ignored bool
+	for _, p := range patterns {
+		// As in gitignore, the last matching pattern wins.
+		if (!p.dirOnly || isDir) && p.re.MatchString(rel) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// parseIgnoreFile parses the patterns in data, which follow gitignore's
+// syntax: blank lines and lines starting with # are skipped, ! negates a
+// pattern, a trailing / only matches directories, and a pattern with a / at
+// the start or in the middle is relative to the module root, while one
+// without matches at any level. *, ? and [...] match within a path element,
+// and ** across elements.
+func parseIgnoreFile(data []byte) ([]ignorePattern, error) {
+	var patterns []ignorePattern
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var p ignorePattern
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+
+		expr := globToRegexp(line)
+		if !anchored {
+			expr = "(.*/)?" + expr
+		}
+		re, err := regexp.Compile("^" + expr + "$")
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern %q", i+1, line)
+		}
+		p.re = re
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// globToRegexp translates a gitignore glob into a regular expression.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return b.String()
+}
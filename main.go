@@ -3,297 +3,1324 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"go/ast"
-	"go/format"
-	"go/importer"
+	"go/build"
 	"go/parser"
 	"go/scanner"
 	"go/token"
 	"go/types"
+	"go/version"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cabify/gofixunkeyedcomposites/unkeyed"
 )
 
 func main() {
-	overwrite := flag.Bool("w", false, "write result to (source) file instead of stdout")
-	list := flag.Bool("l", false, "list files whose formatting differs from gofixunkeyedcomposites's")
-	flag.Usage = func() {
-		fmt.Println(helpMsg)
-		flag.PrintDefaults()
+	os.Exit(Run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// Run runs the command with args, which don't include the program name, and
+// returns its exit status. It doesn't change any global state, so it can be
+// called again, or concurrently, in the same process.
+func Run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("gofixunkeyedcomposites", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	expr := flags.String("e", "", "fix this Go source instead of stdin; the package clause may be left out")
+	stdinFilename := flags.String("stdin-filename", "", "fix stdin as the contents of this file, like an editor's unsaved buffer, type-checking it with the rest of the file's package on disk")
+	pkgDir := flags.String("pkgdir", "", "fix stdin or -e as a file of the package in this directory, instead of the current one, to resolve types")
+	overwrite := flags.Bool("w", false, "write result to (source) file instead of stdout")
+	dryRun := flags.Bool("dry-run", false, "print the fixed files to stdout without modifying any, as by default, but refusing -w")
+	list := flags.Bool("l", false, "list files whose formatting differs from gofixunkeyedcomposites's")
+	doDiff := flags.Bool("d", false, "display diffs instead of rewriting files")
+	gitDiff := flags.Bool("git-diff", false, "display diffs like -d, but with git's headers, to apply them with git apply from the current directory, or -root if set")
+	color := flags.String("color", "auto", "colorize diffs: auto (if stdout is a terminal), always or never")
+	typesReport := flags.Bool("types", false, "print the struct types with unkeyed literals across all files, with how many each has, most first, instead of the fixed source")
+	preview := flags.Bool("preview", false, "print the keys that would be inserted in each literal, and before which elements, instead of the fixed source")
+	quiet := flags.Bool("quiet", false, "don't print anything to stdout; errors are still reported")
+	enforce := flags.String("enforce", "", "comma-separated import paths (or path/... patterns) of packages whose literals must be keyed; report unkeyed literals in them instead of fixing, and exit non-zero if any")
+	verifyKeyed := flags.String("verify-keyed", "", "comma-separated struct types, with package paths as printed by -types, whose literals must not be keyed; report their fully keyed literals instead of fixing, and exit non-zero if any")
+	minFields := flags.Int("min-fields", 0, "only add keys to literals of structs with at least this many fields")
+	onlyRisky := flags.Bool("only-risky", false, "only add keys to literals of structs with two or more consecutive fields of the same type, which are easy to swap")
+	format := flags.String("format", "text", "format of the -enforce and -verify-keyed reports: text, checkstyle or sarif")
+	warnMissing := flags.Bool("warn-missing-imports", false, "report the imports that couldn't be resolved, which leave literals of their types without keys")
+	debug := flags.Bool("debug", false, "print the resolved package and type information per file to stderr")
+	verifyCompile := flags.Bool("verify-compile", false, "type-check each fixed package again and fail on files where adding keys introduced type errors")
+	noFormat := flags.Bool("no-format", false, "only insert the keys, with a space after commas right before them, without formatting the result with gofmt")
+	addHeader := flags.Bool("add-header", false, "add a "+keyedHeader+" comment at the top of the files keys are added to, unless they have it already")
+	minimalDiff := flags.Bool("minimal-diff", false, "only format the declarations keys are added to with gofmt, leaving the rest of the file as is")
+	tags := flags.String("tags", "", "comma-separated list of build tags to consider satisfied when selecting and type-checking files")
+	goos := flags.String("goos", "", "operating system to select files and type-check for, instead of $GOOS or the host's")
+	goarch := flags.String("goarch", "", "architecture to select files and type-check for, instead of $GOARCH or the host's")
+	lang := flags.String("lang", "", "Go language version to type-check with, like go1.21; defaults to the module's go directive")
+	report := flags.String("report", "", "write a JSON report of the literals keys were added to, with their types and fields, to this file")
+	importPrefix := flags.String("import-prefix", "", "only process packages whose import paths start with this prefix")
+	matchExpr := flags.String("match", "", "only process files whose paths, with / as separator, match this regular expression")
+	filesFrom := flags.String("files-from", "", "also process the paths listed in this file, one per line, like the output of -l")
+	fromGoList := flags.String("from-golist", "", "also process the Go files of the packages in this file, or stdin if -, made with go list -json")
+	changed := flags.Bool("changed", false, "process the Go files changed according to git diff against -git-base, staged or not, instead of the given paths")
+	gitBase := flags.String("git-base", "HEAD", "git revision -changed compares the working tree against")
+	fileTimeout := flags.Duration("file-timeout", 0, "give up on files that take longer than this to fix, like 30s, and go on with the rest; 0 means no limit")
+	concurrency := flags.Int("concurrency", runtime.NumCPU(), "number of files to fix at once; output is in order regardless, and 0 fixes them one after the other in the main goroutine")
+	failFast := flags.Bool("fail-fast", false, "stop at the first file that can't be processed, instead of going on with the rest and failing at the end")
+	summaryJSON := flags.String("summary-json", "", "write a JSON summary of the run to this file: files scanned and changed, literals keyed and skipped by reason, duration and errors")
+	root := flags.String("root", "", "report file paths relative to this directory instead of as given")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, helpMsg)
+		flags.PrintDefaults()
+	}
+	parse := func(args []string) int {
+		switch err := flags.Parse(args); {
+		case err == flag.ErrHelp:
+			return 0
+		case err != nil:
+			return 2
+		}
+		return -1
+	}
+	if len(args) > 0 {
+		if cmd, ok := commands[args[0]]; ok {
+			if code := parse(args[1:]); code >= 0 {
+				return code
+			}
+			if !isFlagSet(flags, cmd.flag) {
+				flags.Set(cmd.flag, cmd.value)
+			}
+		}
+	}
+	if !flags.Parsed() {
+		if code := parse(args); code >= 0 {
+			return code
+		}
+	}
+	start := time.Now()
+	fsys := osFS{}
+	paths, err := expandArgFiles(fsys, flags.Args())
+	if err != nil {
+		reportErrs(stderr, err)
+		return 1
+	}
+	if *filesFrom != "" {
+		listed, err := readPathList(fsys, *filesFrom)
+		if err != nil {
+			reportErrs(stderr, err)
+			return 1
+		}
+		if len(listed) == 0 && len(paths) == 0 {
+			// Not stdin, which is what no paths means otherwise.
+			return 0
+		}
+		paths = append(paths, listed...)
+	}
+	if *fromGoList != "" {
+		var r io.Reader = stdin
+		if *fromGoList != "-" {
+			f, err := fsys.Open(*fromGoList)
+			if err != nil {
+				reportErrs(stderr, err)
+				return 1
+			}
+			defer f.Close()
+			r = f
+		}
+		listed, err := goListFiles(r)
+		if err != nil {
+			reportErrs(stderr, err)
+			return 1
+		}
+		if len(listed) == 0 && len(paths) == 0 {
+			// Not stdin, which is what no paths means otherwise.
+			return 0
+		}
+		paths = append(paths, listed...)
+	}
+	if *expr != "" && (len(paths) > 0 || *changed) {
+		fmt.Fprintln(stderr, "can't give paths, -files-from, -from-golist or -changed with -e")
+		return 1
+	}
+	if *changed {
+		if len(paths) > 0 {
+			fmt.Fprintln(stderr, "can't give paths, -files-from or -from-golist with -changed")
+			return 1
+		}
+		paths, err = gitChangedFiles(*gitBase)
+		if err != nil {
+			reportErrs(stderr, err)
+			return 1
+		}
+		if len(paths) == 0 {
+			// Not stdin, which is what no paths means otherwise.
+			return 0
+		}
+	}
+
+	if *stdinFilename != "" && (*expr != "" || *pkgDir != "" || len(paths) > 0) {
+		fmt.Fprintln(stderr, "can't give paths, -e or -pkgdir with -stdin-filename")
+		return 1
+	}
+	if *addHeader && *expr != "" {
+		fmt.Fprintln(stderr, "can't use -add-header with -e; it marks files")
+		return 1
+	}
+	if *pkgDir != "" && len(paths) > 0 {
+		fmt.Fprintln(stderr, "can't use -pkgdir with paths; it only applies to stdin or -e")
+		return 1
+	}
+
+	var colorDiff bool
+	switch *color {
+	case "auto":
+		f, ok := stdout.(*os.File)
+		colorDiff = ok && isTerminal(f)
+	case "always":
+		colorDiff = true
+	case "never":
+	default:
+		fmt.Fprintf(stderr, "invalid -color value %q; must be auto, always or never\n", *color)
+		return 1
+	}
+
+	var match *regexp.Regexp
+	if *matchExpr != "" {
+		match, err = regexp.Compile(*matchExpr)
+		if err != nil {
+			fmt.Fprintf(stderr, "invalid -match value: %s\n", err)
+			return 1
+		}
+	}
+
+	writeReport, ok := reportFormats[*format]
+	if !ok {
+		fmt.Fprintf(stderr, "invalid -format value %q; must be text, checkstyle or sarif\n", *format)
+		return 1
+	}
+
+	if *gitDiff {
+		*doDiff = true
+	}
+	var enforced []string
+	if *enforce != "" {
+		if *overwrite || *doDiff {
+			fmt.Fprintln(stderr, "can't use -w or -d with -enforce")
+			return 1
+		}
+		if *report != "" {
+			fmt.Fprintln(stderr, "can't use -report with -enforce; use -format to choose the report's format")
+			return 1
+		}
+		enforced = strings.Split(*enforce, ",")
+	}
+	var positional map[string]bool
+	if *verifyKeyed != "" {
+		if *overwrite || *doDiff || *list || *report != "" || enforced != nil {
+			fmt.Fprintln(stderr, "can't use -w, -d, -l, -report or -enforce with -verify-keyed")
+			return 1
+		}
+		positional = map[string]bool{}
+		for _, typ := range strings.Split(*verifyKeyed, ",") {
+			positional[strings.TrimSpace(typ)] = true
+		}
+	}
+	if *dryRun && *overwrite {
+		fmt.Fprintln(stderr, "can't use -dry-run with -w or fix")
+		return 1
+	}
+	if *preview && (*overwrite || *doDiff || enforced != nil || positional != nil) {
+		fmt.Fprintln(stderr, "can't use -preview with -w, -d, -enforce or -verify-keyed")
+		return 1
+	}
+	if *typesReport && (*overwrite || *doDiff || *list || *preview || enforced != nil || positional != nil) {
+		fmt.Fprintln(stderr, "can't use -types with -w, -d, -l, -preview, -enforce or -verify-keyed")
+		return 1
+	}
+	toStdout := !*list && !*quiet && !*preview && !*typesReport && enforced == nil && positional == nil
+	if *minimalDiff && *noFormat {
+		fmt.Fprintln(stderr, "can't use -minimal-diff with -no-format")
+		return 1
+	}
+	if *lang != "" && !version.IsValid(*lang) {
+		fmt.Fprintf(stderr, "invalid -lang value %q; must be a Go version like go1.21\n", *lang)
+		return 1
+	}
+	conf := config{
+		fix:           unkeyed.Options{MinFields: *minFields, OnlyRisky: *onlyRisky},
+		lang:          *lang,
+		noFormat:      *noFormat,
+		verifyCompile: *verifyCompile,
+		minimalDiff:   *minimalDiff,
+		addHeader:     *addHeader,
+		timeout:       *fileTimeout,
+		positional:    positional,
+		stdinDir:      *pkgDir,
+		stdinName:     *stdinFilename,
+		ctxt:          buildContext(fsys),
+	}
+	// Also used to import dependencies.
+	if *tags != "" {
+		conf.ctxt.BuildTags = strings.Split(*tags, ",")
+	}
+	if *goos != "" {
+		conf.ctxt.GOOS = *goos
+	}
+	if *goarch != "" {
+		conf.ctxt.GOARCH = *goarch
+	}
+	if *debug {
+		conf.debug = stderr
+	}
+	var diags []diagnostic
+	check := func(name string, lits []unkeyedLit) {
+		for _, lit := range lits {
+			lit.pos.Filename = name
+			diags = append(diags, diagnostic{
+				pos:     lit.pos,
+				message: lit.typ + " struct literal uses unkeyed fields",
+			})
+		}
+	}
+	checkKeyed := func(name string, lits []unkeyedLit) {
+		for _, lit := range lits {
+			lit.pos.Filename = name
+			diags = append(diags, diagnostic{
+				pos:     lit.pos,
+				message: lit.typ + " struct literal uses keyed fields, but must not",
+			})
+		}
+	}
+	showPreview := func(name string, lits []unkeyedLit) {
+		if !*preview || *quiet {
+			return
+		}
+		for _, lit := range lits {
+			fmt.Fprintf(stdout, "%s:%d: insert %s\n", name, lit.pos.Line, strings.Join(lit.inserts, "; "))
+		}
+	}
+	warn := func(name string, warnings []diagnostic) {
+		for i := range warnings {
+			warnings[i].pos.Filename = name
+		}
+		writeTextReport(stderr, warnings)
+	}
+	var changes []unkeyedLit
+	record := func(name string, lits []unkeyedLit) {
+		for _, lit := range lits {
+			lit.pos.Filename = name
+			changes = append(changes, lit)
+		}
+	}
+	type missingImport struct {
+		err   error
+		files int
+	}
+	missing := map[string]*missingImport{}
+	noteMissing := func(importErrs map[string]error) {
+		if !*warnMissing {
+			return
+		}
+		for path, err := range importErrs {
+			if m := missing[path]; m != nil {
+				m.files++
+			} else {
+				missing[path] = &missingImport{err: err, files: 1}
+			}
+		}
+	}
+	summary := runSummary{LiteralsSkipped: map[unkeyed.SkipReason]int{}}
+	typeCounts := map[string]int{}
+	tally := func(res result, changed bool) {
+		if changed {
+			summary.FilesChanged++
+		}
+		summary.LiteralsKeyed += len(res.lits)
+		for _, lit := range res.lits {
+			typeCounts[lit.qualifiedTyp]++
+		}
+		for reason, n := range res.skips {
+			summary.LiteralsSkipped[reason] += n
+		}
+	}
+	var errs []error
+	var skipped int
+	finish := func() int {
+		if *summaryJSON != "" {
+			summary.Duration = time.Since(start).Seconds()
+			summary.Errors = make([]string, 0, len(errs))
+			for _, err := range errs {
+				summary.Errors = append(summary.Errors, err.Error())
+			}
+			if err := writeSummary(fsys, *summaryJSON, summary); err != nil {
+				reportErrs(stderr, err)
+				return 1
+			}
+		}
+		if len(missing) > 0 {
+			paths := make([]string, 0, len(missing))
+			for path := range missing {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			for _, path := range paths {
+				m := missing[path]
+				fmt.Fprintf(stderr, "couldn't import %q, needed by %d files, so literals of its types were left alone: %s\n", path, m.files, m.err)
+			}
+			fmt.Fprintln(stderr, "make sure dependencies are available, for example with go mod download")
+		}
+		if (enforced != nil || positional != nil) && !*quiet {
+			if err := writeReport(stdout, diags); err != nil {
+				reportErrs(stderr, err)
+				return 1
+			}
+		}
+		if *typesReport && !*quiet {
+			writeTypesReport(stdout, typeCounts)
+		}
+		if *report != "" {
+			if err := writeChangesReport(fsys, *report, changes); err != nil {
+				reportErrs(stderr, err)
+				return 1
+			}
+		}
+		if len(diags) > 0 || skipped > 0 {
+			return 1
+		}
+		return 0
 	}
-	flag.Parse()
-	paths := flag.Args()
 
 	if len(paths) == 0 {
+		in, name := stdin, "<standard input>"
+		if *expr != "" {
+			in, name = strings.NewReader(wrapSnippet(fsys, *expr, conf.stdinDir)), "<command line>"
+		}
+		if conf.stdinName != "" {
+			name = conf.stdinName
+			conf.stdinDir = filepath.Dir(conf.stdinName)
+		}
 		if *overwrite {
-			fmt.Fprintln(os.Stderr, "can't use -w on stdin")
-			os.Exit(1)
+			fmt.Fprintf(stderr, "can't use -w on %s\n", name)
+			return 1
+		}
+		if *doDiff {
+			fmt.Fprintf(stderr, "can't use -d on %s\n", name)
+			return 1
+		}
+		if *pkgDir != "" {
+			fi, err := fsys.Stat(*pkgDir)
+			if err != nil {
+				reportErrs(stderr, err)
+				return 1
+			}
+			if !fi.IsDir() {
+				fmt.Fprintf(stderr, "-pkgdir %s is not a directory\n", *pkgDir)
+				return 1
+			}
+		}
+		if pkgPath := importPath(fsys, conf.stdinDir); !strings.HasPrefix(pkgPath, *importPrefix) ||
+			enforced != nil && !matchPkg(pkgPath, enforced) {
+			return 0
 		}
 		var w io.Writer
-		if !*list {
-			w = os.Stdout
+		var buf bytes.Buffer
+		if toStdout {
+			w = stdout
+			if *expr != "" {
+				w = &buf
+			}
 		}
-		fixed, err := fixFile(w, os.Stdin, "")
+		summary.FilesScanned++
+		res, err := fixFile(fsys, w, in, "", conf)
 		if err != nil {
-			reportErrs(err)
-			os.Exit(1)
+			reportErrs(stderr, err)
+			errs = append(errs, err)
+			finish()
+			return 1
 		}
-		if fixed && *list {
-			fmt.Println("<standard input>")
+		tally(res, len(res.lits) > 0)
+		if *expr != "" && toStdout {
+			stdout.Write(unwrapSnippet(fsys, *expr, conf.stdinDir, buf.Bytes()))
 		}
-		return
+		warn(name, res.warnings)
+		noteMissing(res.importErrs)
+		fixed := len(res.lits) > 0
+		if enforced != nil {
+			check(name, res.lits)
+		} else if positional != nil {
+			checkKeyed(name, res.keyed)
+		} else {
+			record(name, res.lits)
+			showPreview(name, res.lits)
+			if fixed && *list && !*quiet {
+				fmt.Fprintln(stdout, name)
+			}
+		}
+		return finish()
 	}
 
+	// Files are first collected, and filtered, in order.
+	type file struct {
+		path, absPath, name string
+	}
+	var files []file
+	seen := map[string]bool{}
+	collect := func(path string) error {
+		if match != nil && !match.MatchString(filepath.ToSlash(path)) {
+			return nil
+		}
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		id := absPath
+		if real, err := fsys.EvalSymlinks(absPath); err == nil {
+			id = real
+		}
+		if seen[id] {
+			// Given more than once, maybe through a directory or a symlink.
+			return nil
+		}
+		seen[id] = true
+		if enforced != nil || *importPrefix != "" {
+			pkgPath := importPath(fsys, filepath.Dir(absPath))
+			if !strings.HasPrefix(pkgPath, *importPrefix) {
+				return nil
+			}
+			if enforced != nil && !matchPkg(pkgPath, enforced) {
+				return nil
+			}
+		}
+		files = append(files, file{path: path, absPath: absPath, name: displayPath(*root, path, absPath)})
+		return nil
+	}
+	ig := newIgnorer(fsys)
 	for _, path := range paths {
+		// Directories without any Go file are most likely a mistake, but
+		// not if the files in them were ignored on purpose.
+		var found, ignored int
+		skip := func(path string, isDir bool) (bool, error) {
+			skipped, err := ig.ignored(path, isDir)
+			if !skipped && err == nil && !isDir {
+				// Like siblings when type-checking. Files a constraint
+				// can't be read from are left to fail when fixed.
+				ok, err := conf.ctxt.MatchFile(filepath.Split(path))
+				skipped = !ok && err == nil
+			}
+			if skipped {
+				ignored++
+			}
+			return skipped, err
+		}
+		count := func(path string) error {
+			found++
+			return collect(path)
+		}
+		err := walkGoFiles(fsys, path, skip, count)
+		if err == nil && found == 0 && ignored == 0 {
+			err = fmt.Errorf("no Go files found in %s", path)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			if *failFast {
+				break
+			}
+		}
+	}
+	if *failFast && len(errs) > 0 {
+		// Nothing is fixed, but the reports are still written.
+		files = nil
+	}
+
+	// Then they're fixed, maybe concurrently, and the outcome of each one is
+	// reported, in order.
+	type fixedFile struct {
+		file
+		res  result
+		out  *bytes.Buffer // The fixed source, if needed after fixing.
+		diff []byte
+		err  error
+		// debug is the -debug output, written when the file is reported so
+		// that it's in order and not written concurrently.
+		debug []byte
+	}
+	sequential := *concurrency <= 0
+	// With concurrency, fixed source for stdout is buffered to keep it in
+	// order.
+	bufferStdout := toStdout && !*overwrite && !*doDiff && !sequential
+	prepare := func(f file) (ff fixedFile) {
+		ff.file = f
 		var w io.Writer
-		var buf *bytes.Buffer
-		if *overwrite {
-			buf = bytes.NewBuffer(nil)
-			w = buf
-		} else if !*list {
-			w = os.Stdout
+		if *overwrite || *doDiff || bufferStdout {
+			ff.out = bytes.NewBuffer(nil)
+			w = ff.out
+		} else if toStdout {
+			w = stdout
+		}
+		conf := conf
+		var debug bytes.Buffer
+		if conf.debug != nil {
+			conf.debug = &debug
+		}
+		ff.res, ff.err = fixFile(fsys, w, nil, f.absPath, conf)
+		ff.debug = debug.Bytes()
+		if ff.err != nil {
+			// Named like everything else reported about the file.
+			ff.err = renameErr(ff.err, f.absPath, f.name)
+		}
+		if ff.err != nil || len(ff.res.lits) == 0 || !*doDiff || *quiet {
+			return ff
 		}
 
-		absPath, err := filepath.Abs(path)
+		var err error
+		ff.diff, err = diff(ff.res.src, ff.out.Bytes(), f.name)
 		if err != nil {
-			reportErrs(err)
-			os.Exit(1)
+			ff.err = fmt.Errorf("computing diff: %s", err)
+			return ff
 		}
-		fixed, err := fixFile(w, nil, absPath)
-		if err != nil {
-			reportErrs(err)
-			os.Exit(1)
+		if *gitDiff {
+			name, err := gitPath(*root, f.absPath)
+			if err != nil {
+				ff.err = fmt.Errorf("%s: %s", f.name, err)
+				return ff
+			}
+			ff.diff = gitHeaders(ff.diff, name)
+		}
+		if colorDiff {
+			ff.diff = colorize(ff.diff)
+		}
+		return ff
+	}
+	emit := func(ff fixedFile) error {
+		stderr.Write(ff.debug)
+		if ff.err != nil {
+			return ff.err
 		}
+		if bufferStdout {
+			stdout.Write(ff.out.Bytes())
+		}
+		res, name := ff.res, ff.name
+		warn(name, res.warnings)
+		noteMissing(res.importErrs)
+		fixed := len(res.lits) > 0
 
-		if fixed && *list {
-			fmt.Println(path)
+		if enforced != nil {
+			check(name, res.lits)
+		} else if positional != nil {
+			checkKeyed(name, res.keyed)
+		} else if fixed && *list && !*quiet {
+			fmt.Fprintln(stdout, name)
 		}
-		if *overwrite {
-			err := ioutil.WriteFile(path, buf.Bytes(), 0655)
+		showPreview(name, res.lits)
+		stdout.Write(ff.diff)
+		if fixed && *overwrite {
+			err := fsys.ReplaceFile(ff.path, ff.out.Bytes(), 0655)
+			if errors.Is(err, fs.ErrPermission) {
+				fmt.Fprintf(stderr, "%s: skipped, file is read-only or not writable; make it writable and run again to fix it\n", name)
+				skipped++
+				tally(res, false)
+				return nil
+			}
 			if err != nil {
-				reportErrs(err)
-				os.Exit(1)
+				return err
+			}
+		}
+		if enforced == nil {
+			record(name, res.lits)
+		}
+		tally(res, fixed)
+		return nil
+	}
+
+	var results []chan fixedFile
+	var slots chan struct{}
+	// With -fail-fast, reporting may stop before every file is fixed, so
+	// done stops starting more, and the ones started are waited for, so that
+	// nothing is left running once Run returns.
+	done := make(chan struct{})
+	var running sync.WaitGroup
+	if !sequential {
+		results = make([]chan fixedFile, len(files))
+		for i := range results {
+			results[i] = make(chan fixedFile, 1)
+		}
+		// Slots are freed as files are reported, not as they're fixed, so
+		// that fixed files waiting for a slow one before them don't pile up.
+		slots = make(chan struct{}, *concurrency)
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			for i, f := range files {
+				select {
+				case slots <- struct{}{}:
+				case <-done:
+					return
+				}
+				running.Add(1)
+				go func(i int, f file) {
+					defer running.Done()
+					results[i] <- prepare(f)
+				}(i, f)
+			}
+		}()
+	}
+	for i, f := range files {
+		var ff fixedFile
+		if sequential {
+			ff = prepare(f)
+		} else {
+			ff = <-results[i]
+		}
+		summary.FilesScanned++
+		err := emit(ff)
+		if !sequential {
+			<-slots
+		}
+		if err != nil {
+			errs = append(errs, err)
+			if *failFast {
+				// The files fixed so far are still reported.
+				break
 			}
 		}
 	}
+	close(done)
+	running.Wait()
+
+	if len(errs) > 0 {
+		reportErrs(stderr, errs...)
+	}
+	code := finish()
+	if len(errs) > 0 {
+		return 1
+	}
+	return code
 }
 
 const helpMsg = `gofixunkeyedcomposites adds keys to composite literal fields.
 
 Usage:
 
-	gofixunkeyedcomposites [options] [path ...]
+	gofixunkeyedcomposites [command] [options] [path ...]
+
+Commands:
+
+	fix    write the fixed files in place (-w)
+	diff   display diffs of the fixes (-d)
+	list   list the files that would be fixed (-l)
+	check  report unkeyed literals and fail if there are any (-enforce ...)
+
+Without a command, the fixed files are printed to stdout, unless options
+say otherwise, and no file is modified; -dry-run makes that explicit. Use
+./fix to process a file or directory named like a command.
+
+Directories are processed recursively, so dir and dir/... are the same.
+Like with the go command, vendor and testdata directories and those starting
+with . or _ are skipped unless given as arguments, and so are the paths
+matched by the .gofixignore file at the module's root, if any, which follows
+the gitignore syntax. Files that build constraints exclude, as evaluated with
+-tags, -goos and -goarch, are skipped too unless given. An @argfile argument
+is replaced by the paths listed in argfile, one per line.
+
+To review which files would change before changing them:
+
+	gofixunkeyedcomposites list ./... > changes.txt
+	gofixunkeyedcomposites fix -files-from changes.txt
 
 Options:
 `
 
-func reportErrs(errs ...error) {
+// wrapSnippet returns src, given with -e, as the contents of a Go file of the
+// package in dir. If it has no package clause, it gets one for the package's
+// name, or main, on the same line so that positions in it are kept.
+func wrapSnippet(fsys fileSystem, src, dir string) string {
+	fset := token.NewFileSet()
+	if _, err := parser.ParseFile(fset, "", src, parser.PackageClauseOnly); err == nil {
+		return src
+	}
+	return snippetPackage(fsys, dir) + ";" + src
+}
+
+// unwrapSnippet removes from out, the fixed -e source src, the package clause
+// added by wrapSnippet, if any.
+func unwrapSnippet(fsys fileSystem, src, dir string, out []byte) []byte {
+	if wrapSnippet(fsys, src, dir) == src {
+		return out
+	}
+	out = bytes.TrimPrefix(out, []byte(snippetPackage(fsys, dir)))
+	return bytes.TrimLeft(out, ";\n")
+}
+
+func snippetPackage(fsys fileSystem, dir string) string {
+	if dir == "" {
+		dir = "."
+	}
+	if p, _ := buildContext(fsys).ImportDir(dir, 0); p != nil && p.Name != "" {
+		return "package " + p.Name
+	}
+	return "package main"
+}
+
+// commands are the subcommands, each one the same as setting a flag.
+var commands = map[string]struct{ flag, value string }{
+	"fix":   {"w", "true"},
+	"diff":  {"d", "true"},
+	"list":  {"l", "true"},
+	"check": {"enforce", "..."},
+}
+
+func isFlagSet(flags *flag.FlagSet, name string) bool {
+	var set bool
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// displayPath returns the path to report for the file at path, whose absolute
+// path is absPath: relative to root if set, or path itself otherwise.
+func displayPath(root, path, absPath string) string {
+	if root == "" {
+		return path
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(absRoot, absPath)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// expandArgFiles replaces every @argfile argument in args with the paths
+// listed in argfile, and every dir/... argument with dir, since directories
+// are processed recursively anyway.
+func expandArgFiles(fsys fileSystem, args []string) ([]string, error) {
+	var expanded []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") {
+			expanded = append(expanded, trimDots(arg))
+			continue
+		}
+		paths, err := readPathList(fsys, arg[1:])
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, paths...)
+	}
+	return expanded, nil
+}
+
+// readPathList returns the paths in the file name, one per line, skipping
+// empty lines.
+func readPathList(fsys fileSystem, name string) ([]string, error) {
+	data, err := fsys.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			paths = append(paths, trimDots(line))
+		}
+	}
+	return paths, nil
+}
+
+// trimDots turns a dir/... pattern into dir.
+func trimDots(path string) string {
+	if path == "..." {
+		return "."
+	}
+	if dir := strings.TrimSuffix(path, "/..."); dir != path {
+		return dir
+	}
+	return path
+}
+
+// renameErr returns err, about the file at path, with name instead of path
+// in its positions and message.
+func renameErr(err error, path, name string) error {
+	if path == name {
+		return err
+	}
+	if list, ok := err.(scanner.ErrorList); ok {
+		renamed := make(scanner.ErrorList, len(list))
+		for i, e := range list {
+			e := *e
+			if e.Pos.Filename == path {
+				e.Pos.Filename = name
+			}
+			renamed[i] = &e
+		}
+		return renamed
+	}
+	return errors.New(strings.ReplaceAll(err.Error(), path, name))
+}
+
+// reportErrs prints errs to w, one per line. Identical messages are
+// printed once, with the number of times they occurred.
+func reportErrs(w io.Writer, errs ...error) {
+	var msgs []string
+	counts := map[string]int{}
+	add := func(err error) {
+		msg := err.Error()
+		if counts[msg] == 0 {
+			msgs = append(msgs, msg)
+		}
+		counts[msg]++
+	}
 	for _, err := range errs {
 		if errs, ok := err.(scanner.ErrorList); ok {
 			for _, err := range errs {
-				fmt.Fprintln(os.Stderr, err)
+				add(err)
 			}
 		} else {
-			fmt.Fprintln(os.Stderr, err)
+			add(err)
 		}
 	}
+
+	for _, msg := range msgs {
+		if n := counts[msg]; n > 1 {
+			fmt.Fprintf(w, "%s (repeated %d times)\n", msg, n)
+		} else {
+			fmt.Fprintln(w, msg)
+		}
+	}
+}
+
+// unkeyedLit is a composite literal that fixFile added keys to, or would have
+// if it was writing any output.
+type unkeyedLit struct {
+	pos token.Position
+	typ string
+	// qualifiedTyp is typ with full package paths, which tells apart types
+	// from different packages.
+	qualifiedTyp string
+	fields       []string
+	// inserts describe each key inserted, like "X: " before 1.
+	inserts []string
 }
 
-func fixFile(w io.Writer, r io.Reader, path string) (fixed bool, err error) {
+// result is what fixFile found in a file.
+type result struct {
+	// src is the source as read, once, to parse and fix it, so that it
+	// always matches the output even if the file changes meanwhile.
+	src      []byte
+	lits     []unkeyedLit
+	warnings []diagnostic
+	// importErrs are why the imports that couldn't be resolved failed, by
+	// import path.
+	importErrs map[string]error
+	// skips count the literals that were left alone, by reason.
+	skips map[unkeyed.SkipReason]int
+	// keyed are the fully keyed literals of the config's positional types.
+	keyed []unkeyedLit
+}
+
+// config controls how fixFile loads and fixes files.
+type config struct {
+	fix unkeyed.Options
+	// lang is the Go language version to type-check with. If empty, it's
+	// taken from the enclosing module's go directive, if any.
+	lang string
+	// noFormat skips formatting the output with gofmt.
+	noFormat bool
+	// minimalDiff limits formatting to the declarations keys are added to.
+	minimalDiff bool
+	// verifyCompile type-checks the fixed file again and fails if that
+	// introduces type errors.
+	verifyCompile bool
+	// addHeader marks the files keys are added to with keyedHeader.
+	addHeader bool
+	// ctxt is the build context to load files with. If nil, it's made
+	// with buildContext.
+	ctxt *build.Context
+	// stdinDir is the directory of the package stdin is fixed as part of.
+	// If empty, it's the current directory.
+	stdinDir string
+	// stdinName, if not empty, is the file stdin is fixed as, instead of
+	// as an extra file in stdinDir, which must then be its directory.
+	stdinName string
+	// timeout, if positive, limits how long fixing a single file can take.
+	timeout time.Duration
+	// positional are the struct types, fully qualified, whose fully keyed
+	// literals are looked for.
+	positional map[string]bool
+	// debug, if not nil, gets information about how each file was loaded.
+	debug io.Writer
+}
+
+func fixFile(fsys fileSystem, w io.Writer, r io.Reader, path string, conf config) (res result, err error) {
+	if conf.timeout > 0 {
+		return fixFileTimeout(fsys, w, r, path, conf)
+	}
+
 	dir := "."
 	if path != "" {
 		dir = filepath.Dir(path)
+	} else if conf.stdinDir != "" {
+		dir = conf.stdinDir
 	}
 
-	fset := token.NewFileSet()
-	pkgs, err := parser.ParseDir(fset, dir, nil, parser.ParseComments)
-	if err != nil {
-		return false, err
+	load := unkeyed.LoadConfig{
+		Context:   conf.ctxt,
+		GoVersion: conf.lang,
 	}
-
-	var pkg *ast.Package
-	var file *ast.File
-
+	if load.Context == nil {
+		load.Context = buildContext(fsys)
+	}
+	if load.GoVersion == "" {
+		load.GoVersion = moduleGoVersion(fsys, dir)
+	}
+	// From the module root, or GOPATH, rather than the current directory.
+	load.PkgPath = importPath(fsys, dir)
 	if path == "" {
-		file, err = parser.ParseFile(fset, "stdin.go", r, 0)
+		// Fix stdin in place of the file it's named after, if any, or as if
+		// it was an extra file in its directory.
+		src, err := ioutil.ReadAll(r)
 		if err != nil {
-			return false, err
-		}
-		var ok bool
-		pkg, ok = findPkgForStdinFile(fset, pkgs, file)
-		if !ok {
-			pkg, err = ast.NewPackage(fset, map[string]*ast.File{
-				"stdin.go": file,
-			}, nil, nil)
-			if err != nil {
-				return false, err
-			}
+			return res, err
 		}
-	} else {
-		var ok bool
-		pkg, file, ok = findPkgForFile(fset, pkgs, path)
-		if !ok {
-			return false, fmt.Errorf("%s: not a Go file within a package", path)
+		name := conf.stdinName
+		if name == "" {
+			name = filepath.Join(dir, "stdin.go")
+		}
+		path, err = filepath.Abs(name)
+		if err != nil {
+			return res, err
 		}
+		load.Overlay = map[string][]byte{path: src}
+	}
+
+	f, err := unkeyed.Load(path, load)
+	if err != nil {
+		return res, err
 	}
 
-	cfg := &types.Config{
-		Error: func(error) {
-			// Just ignore typing errors; not our concern.
-		},
-		Importer:                 importer.For("source", nil).(types.ImporterFrom),
-		DisableUnusedImportCheck: true,
+	res.src = f.Src
+	res.importErrs = f.ImportErrors
+
+	opts := conf.fix
+	opts.Warn = func(pos token.Pos, msg string) {
+		// Positions are reported in the file as is, even if //line
+		// directives say it was generated from another one.
+		res.warnings = append(res.warnings, diagnostic{pos: f.Fset.PositionFor(pos, false), message: msg})
 	}
-	info := &types.Info{
-		Types: map[ast.Expr]types.TypeAndValue{},
+	opts.Skip = func(lit *ast.CompositeLit, reason unkeyed.SkipReason) {
+		if res.skips == nil {
+			res.skips = map[unkeyed.SkipReason]int{}
+		}
+		res.skips[reason]++
 	}
-	astFiles := make([]*ast.File, 0, len(pkg.Files))
-	for _, f := range pkg.Files {
-		astFiles = append(astFiles, f)
+	found := opts.Literals(f.Fset, f.AST, f.Info)
+	if conf.positional != nil {
+		res.keyed = keyedLits(f, conf.positional)
 	}
 
-	cwd, err := os.Getwd()
-	if err != nil {
-		return false, err
+	if conf.debug != nil {
+		fmt.Fprintf(conf.debug, "%s: package %s (%s), type-checked with %d files, %d errors\n",
+			path, f.Pkg.Name(), importPath(fsys, dir), len(f.Files), len(f.TypeErrors))
+		var lits, typed int
+		ast.Inspect(f.AST, func(n ast.Node) bool {
+			if lit, ok := n.(*ast.CompositeLit); ok {
+				lits++
+				if tv, ok := f.Info.Types[lit]; ok && tv.Type != nil && tv.Type != types.Typ[types.Invalid] {
+					typed++
+				}
+			}
+			return true
+		})
+		fmt.Fprintf(conf.debug, "%s: %d composite literals, %d with type information, %d to key\n",
+			path, lits, typed, len(found))
 	}
-	cfg.Check(cwd, fset, astFiles, info)
 
-	v := &visitor{file: fset.File(file.Pos()), types: info.Types}
-	if w != nil {
-		v.in, err = ioutil.ReadFile(path)
-		if err != nil {
-			return false, err
+	var edits []unkeyed.Edit
+	for _, lit := range found {
+		edits = append(edits, lit.Edits...)
+	}
+	if len(edits) > 0 && conf.addHeader {
+		if edit, ok := headerEdit(f); ok {
+			edits = append(edits, edit)
 		}
 	}
-
-	ast.Walk(v, file)
-
-	if w != nil {
-		out, err := format.Source(v.out())
+	switch {
+	case len(edits) == 0:
+		// Output exactly what was read for files without changes, even if
+		// they aren't formatted, so that fixed files are those that differ.
+		if w != nil {
+			_, err = w.Write(f.Src)
+			if err != nil {
+				return res, err
+			}
+		}
+	case conf.verifyCompile:
+		// The output is needed in full to type-check it before writing it.
+		out := unkeyed.Apply(f.Src, unkeyed.Spaced(f.Src, edits))
+		if !conf.noFormat {
+			out, err = formatFixed(path, f, edits, conf)
+			if err != nil {
+				return res, err
+			}
+		}
+		if err := verifyCompile(path, load, f, out); err != nil {
+			return res, err
+		}
+		if w != nil {
+			_, err = w.Write(out)
+			if err != nil {
+				return res, err
+			}
+		}
+	case w == nil:
+	case conf.noFormat:
+		// Stream the edited source instead of building a copy of it.
+		err := unkeyed.Write(w, f.Src, unkeyed.Spaced(f.Src, edits))
 		if err != nil {
-			return false, err
+			return res, err
+		}
+	default:
+		out, err := formatFixed(path, f, edits, conf)
+		if err != nil {
+			return res, err
 		}
-
 		_, err = io.Copy(w, bytes.NewReader(out))
 		if err != nil {
-			return false, err
+			return res, err
 		}
-
 	}
 
-	return v.fixed, err
+	for _, lit := range found {
+		var inserts []string
+		for i, edit := range lit.Edits {
+			elt := lit.Lit.Elts[i]
+			src := f.Src[edit.Offset:f.Fset.File(elt.Pos()).Offset(elt.End())]
+			inserts = append(inserts, fmt.Sprintf("%q before %s", edit.Text, abbrev(string(src))))
+		}
+		typ := pointedTo(lit.Type)
+		res.lits = append(res.lits, unkeyedLit{
+			pos:          f.Fset.PositionFor(lit.Lit.Pos(), false),
+			typ:          types.TypeString(typ, types.RelativeTo(f.Pkg)),
+			qualifiedTyp: types.TypeString(typ, nil),
+			fields:       lit.Fields,
+			inserts:      inserts,
+		})
+	}
+	return res, nil
 }
 
-type chunk struct {
-	offset int
-	b      []byte
+// pointedTo returns the type typ points to, if it's a pointer, or typ. Literals
+// with elided & have pointer types, but are reported by the struct type.
+func pointedTo(typ types.Type) types.Type {
+	if p, ok := types.Unalias(typ).(*types.Pointer); ok {
+		return p.Elem()
+	}
+	return typ
 }
 
-type visitor struct {
-	file  *token.File
-	types map[ast.Expr]types.TypeAndValue
-	in    []byte
-
-	added []chunk
-
-	fixed bool
+// keyedLits returns the fully keyed literals in f of the struct types in typs,
+// given as printed by types.TypeString with full package paths. Like when
+// fixing, literals with elided & match the types they point to.
+func keyedLits(f *unkeyed.File, typs map[string]bool) []unkeyedLit {
+	var lits []unkeyedLit
+	ast.Inspect(f.AST, func(n ast.Node) bool {
+		lit, ok := n.(*ast.CompositeLit)
+		if !ok || len(lit.Elts) == 0 {
+			return true
+		}
+		for _, elt := range lit.Elts {
+			if _, ok := elt.(*ast.KeyValueExpr); !ok {
+				return true
+			}
+		}
+		typ := f.Info.Types[lit].Type
+		if typ == nil {
+			return true
+		}
+		typ = pointedTo(typ)
+		if _, ok := typ.Underlying().(*types.Struct); !ok || !typs[types.TypeString(typ, nil)] {
+			return true
+		}
+		lits = append(lits, unkeyedLit{
+			pos:          f.Fset.PositionFor(lit.Pos(), false),
+			typ:          types.TypeString(typ, types.RelativeTo(f.Pkg)),
+			qualifiedTyp: types.TypeString(typ, nil),
+		})
+		return true
+	})
+	return lits
 }
 
-func (v *visitor) out() []byte {
-	sort.Slice(v.added, func(i, j int) bool {
-		return v.added[i].offset < v.added[j].offset
-	})
+// keyedHeader is the comment -add-header marks fixed files with.
+const keyedHeader = "// keyed by gofixunkeyedcomposites"
 
-	var out []byte
+// headerEdit returns the edit that adds keyedHeader at the top of f, apart
+// from what follows so that it doesn't become the package's doc comment, or
+// false if one of the comments before the package clause is it already.
+func headerEdit(f *unkeyed.File) (unkeyed.Edit, bool) {
+	for _, group := range f.AST.Comments {
+		if group.Pos() > f.AST.Package {
+			break
+		}
+		for _, c := range group.List {
+			if c.Text == keyedHeader {
+				return unkeyed.Edit{}, false
+			}
+		}
+	}
 	var offset int
-	for _, chunk := range v.added {
-		out = append(out, v.in[offset:chunk.offset]...)
-		out = append(out, chunk.b...)
-		offset = chunk.offset
+	if bytes.HasPrefix(f.Src, []byte("\ufeff")) {
+		offset = len("\ufeff")
 	}
-	out = append(out, v.in[offset:]...)
-
-	return out
+	return unkeyed.Edit{Offset: offset, Text: keyedHeader + "\n\n"}, true
 }
 
-func (v *visitor) writeAfter(pos token.Pos, s string) {
-	v.added = append(v.added, chunk{offset: v.file.Offset(pos), b: []byte(s)})
-}
+// fixFileTimeout is fixFile, but gives up after conf.timeout. The abandoned
+// work goes on in the background, but its output, debugging included, is
+// discarded.
+func fixFileTimeout(fsys fileSystem, w io.Writer, r io.Reader, path string, conf config) (result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), conf.timeout)
+	defer cancel()
 
-func (v *visitor) Visit(node ast.Node) ast.Visitor {
-	lit, ok := node.(*ast.CompositeLit)
-	if !ok {
-		return v
+	type fixed struct {
+		res result
+		err error
 	}
+	done := make(chan fixed, 1)
+	var buf, debug bytes.Buffer
+	go func(conf config) {
+		conf.timeout = 0
+		var out io.Writer
+		if w != nil {
+			out = &buf
+		}
+		if conf.debug != nil {
+			conf.debug = &debug
+		}
+		res, err := fixFile(fsys, out, r, path, conf)
+		done <- fixed{res, err}
+	}(conf)
 
-	typ, ok := v.types[lit]
-	if !ok {
-		return v
-	}
-	s, ok := assertStructType(typ.Type)
-	if !ok {
-		return v
+	select {
+	case f := <-done:
+		if conf.debug != nil {
+			conf.debug.Write(debug.Bytes())
+		}
+		if f.err != nil {
+			return f.res, f.err
+		}
+		if w != nil {
+			_, err := io.Copy(w, &buf)
+			if err != nil {
+				return f.res, err
+			}
+		}
+		return f.res, nil
+	case <-ctx.Done():
+		name := path
+		if name == "" {
+			name = "<standard input>"
+		}
+		return result{}, fmt.Errorf("%s: gave up after %s", name, conf.timeout)
 	}
+}
 
-	if s.NumFields() == 0 {
-		// Empty struct; no keys to add.
-		return v
+// abbrev returns src on a single line, shortened to a few dozen characters.
+func abbrev(src string) string {
+	const max = 30
+	s := strings.Join(strings.Fields(src), " ")
+	if r := []rune(s); len(r) > max {
+		s = string(r[:max-3]) + "..."
 	}
-	if len(lit.Elts) != s.NumFields() {
-		// Either already has keys or missing fields; nothing to add.
-		return v
+	return s
+}
+
+// formatFixed returns the source of f, loaded from path, with edits applied,
+// formatted as conf says.
+//
+// Formatting only fails if the edits produced invalid code, which is a bug.
+// To help reporting it, with conf.debug the unformatted output is kept in a
+// temporary file.
+func formatFixed(path string, f *unkeyed.File, edits []unkeyed.Edit, conf config) ([]byte, error) {
+	var out []byte
+	var err error
+	if conf.minimalDiff {
+		out, err = unkeyed.FormatMinimal(f.Fset, f.AST, f.Src, edits)
+	} else {
+		out, err = unkeyed.Format(f.Src, edits)
 	}
-	if len(lit.Elts) > 0 {
-		if _, ok := lit.Elts[0].(*ast.KeyValueExpr); ok {
-			// Already has keys; nothing to add.
-			return v
+	if err == nil {
+		if bytes.Equal(out, f.Src) {
+			// Keys were added, so this is a bug, and the file would be
+			// reported as fixed while staying the same.
+			return nil, fmt.Errorf("%s: internal error: adding keys left the file unchanged", path)
 		}
+		return out, nil
 	}
-
-	if v.in != nil {
-		for i := 0; i < s.NumFields(); i++ {
-			v.writeAfter(lit.Elts[i].Pos(), s.Field(i).Name()+": ")
-		}
+	if conf.debug == nil {
+		return nil, fmt.Errorf("%s: formatting the fixed file: %s (run with -debug to keep the unformatted output)", path, err)
 	}
-
-	v.fixed = true
-
-	return v
+	tmp, tmpErr := writeTempFile("", "gofixunkeyedcomposites", unkeyed.Apply(f.Src, edits))
+	if tmpErr != nil {
+		return nil, fmt.Errorf("%s: formatting the fixed file: %s (couldn't keep the unformatted output: %s)", path, err, tmpErr)
+	}
+	return nil, fmt.Errorf("%s: formatting the fixed file: %s (unformatted output kept in %s)", path, err, tmp)
 }
 
-func assertStructType(typ types.Type) (*types.Struct, bool) {
-	if p, ok := typ.(*types.Pointer); ok {
-		typ = p.Elem()
+// verifyCompile type-checks the package of f, loaded from path with load,
+// again with out as the file's contents, and fails if that finds any type
+// error that wasn't already there. Errors are compared by message only, since
+// adding keys moves positions around.
+func verifyCompile(path string, load unkeyed.LoadConfig, f *unkeyed.File, out []byte) error {
+	overlay := map[string][]byte{}
+	for name, src := range load.Overlay {
+		overlay[name] = src
 	}
-	if n, ok := typ.(*types.Named); ok {
-		typ = n.Underlying()
+	overlay[path] = out
+	load.Overlay = overlay
+
+	fixed, err := unkeyed.Load(path, load)
+	if err != nil {
+		return fmt.Errorf("%s: verifying the fixed file: %s", path, err)
 	}
-	s, ok := typ.(*types.Struct)
-	return s, ok
-}
 
-func findPkgForFile(fset *token.FileSet, pkgs map[string]*ast.Package, path string) (*ast.Package, *ast.File, bool) {
-	for _, pkg := range pkgs {
-		for fileName, file := range pkg.Files {
-			if fileName == filepath.Clean(path) {
-				return pkg, file, true
-			}
+	before := map[string]int{}
+	for _, err := range f.TypeErrors {
+		before[typeErrorMsg(err)]++
+	}
+	var added []string
+	for _, err := range fixed.TypeErrors {
+		msg := typeErrorMsg(err)
+		if before[msg] > 0 {
+			before[msg]--
+			continue
 		}
+		added = append(added, err.Error())
 	}
-
-	return nil, nil, false
+	if len(added) > 0 {
+		return fmt.Errorf("%s: adding keys introduces type errors, leaving it unchanged:\n\t%s",
+			path, strings.Join(added, "\n\t"))
+	}
+	return nil
 }
 
-func findPkgForStdinFile(fset *token.FileSet, pkgs map[string]*ast.Package, stdinFile *ast.File) (*ast.Package, bool) {
-	for pkgName, pkg := range pkgs {
-		if pkgName == stdinFile.Name.Name {
-			return pkg, true
-		}
+func typeErrorMsg(err error) string {
+	if terr, ok := err.(types.Error); ok {
+		return terr.Msg
 	}
-	return nil, false
+	return err.Error()
 }
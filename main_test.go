@@ -0,0 +1,1111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"go/version"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cabify/gofixunkeyedcomposites/unkeyed"
+)
+
+// The modules in testdata/fix are fixed in place by TestFix, after which each
+// Go file in them must match the file with the same name plus .golden, if
+// any, or be left unchanged otherwise, and the module must still build.
+// Adding a case is a matter of adding a module there.
+
+func TestFix(t *testing.T) {
+	moduleEnv(t)
+	dirs, err := filepath.Glob(filepath.Join("testdata", "fix", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		dir := dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			tmp := copyFixture(t, dir)
+			stdout, stderr, code := run(t, "", "fix", tmp)
+			if code != 0 || stdout != "" || stderr != "" {
+				t.Fatalf("exit status %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+			}
+			checkGolden(t, dir, tmp)
+			checkBuild(t, tmp)
+		})
+	}
+}
+
+// TestFixFile runs every Go file of the modules in testdata/fix through
+// fixFile, in memory, and compares the output with the file's golden version,
+// or with the file itself if it has none, like TestFix does once the files are
+// fixed in place. Files excluded by build constraints, which TestFix's walk
+// skips, are skipped too.
+func TestFixFile(t *testing.T) {
+	moduleEnv(t)
+	dir := fixture(t, "")
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".go") {
+			return err
+		}
+		if ok, err := build.Default.MatchFile(filepath.Split(path)); !ok || err != nil {
+			return err
+		}
+		want, err := os.ReadFile(path + ".golden")
+		if os.IsNotExist(err) {
+			want, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return err
+		}
+		var out bytes.Buffer
+		res, err := fixFile(osFS{}, &out, nil, path, config{})
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+			return nil
+		}
+		if rel, _ := filepath.Rel(dir, path); out.String() != string(want) {
+			t.Errorf("%s:\n%s\nwant:\n%s", rel, out.String(), want)
+		} else if unchanged := bytes.Equal(res.src, want); unchanged != (len(res.lits) == 0) {
+			t.Errorf("%s: %d literals keyed, with the output unchanged: %v", rel, len(res.lits), unchanged)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStdin(t *testing.T) {
+	moduleEnv(t)
+	chdir(t, copyFixture(t, fixture(t, "nested")))
+
+	in := "package nested\n\nimport \"example.com/nested/c\"\n\nvar (\n\td = c.D{1, 2}\n\tb = B{1, c.D{2, 3}}\n)\n"
+	want := "package nested\n\nimport \"example.com/nested/c\"\n\nvar (\n\td = c.D{X: 1, Y: 2}\n\tb = B{N: 1, D: c.D{X: 2, Y: 3}}\n)\n"
+	stdout, stderr, code := run(t, in)
+	if code != 0 || stderr != "" {
+		t.Fatalf("exit status %d, stderr:\n%s", code, stderr)
+	}
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+func TestList(t *testing.T) {
+	moduleEnv(t)
+	fixtures := fixture(t, "")
+	chdir(t, copyFixture(t, fixtures))
+
+	var want []string
+	err := filepath.Walk(fixtures, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !strings.HasSuffix(path, ".go.golden") {
+			return err
+		}
+		rel, err := filepath.Rel(fixtures, strings.TrimSuffix(path, ".golden"))
+		want = append(want, rel)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(want)
+
+	stdout, stderr, code := run(t, "", "-l", ".")
+	if code != 0 || stderr != "" {
+		t.Fatalf("exit status %d, stderr:\n%s", code, stderr)
+	}
+	if got := strings.Fields(stdout); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("listed:\n%s\nwant:\n%s", stdout, strings.Join(want, "\n"))
+	}
+}
+
+// TestWalk checks that directories are walked skipping those the go command
+// ignores, unless they're given.
+func TestWalk(t *testing.T) {
+	moduleEnv(t)
+	chdir(t, t.TempDir())
+	writeFile(t, "go.mod", "module example.com/walk\n\ngo 1.22\n")
+	const src = "package x\n\ntype T struct{ A, B int }\n\nvar t = T{1, 2}\n"
+	for _, path := range []string{"a.go", "vendor/v/v.go", "testdata/t.go", "b/testdata/t.go", "_x/x.go", ".x/x.go"} {
+		writeFile(t, path, src)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"."}, "a.go\n"},
+		{[]string{"./..."}, "a.go\n"},
+		{[]string{"testdata", "b/testdata", "_x"}, "testdata/t.go\nb/testdata/t.go\n_x/x.go\n"},
+	} {
+		stdout, stderr, code := run(t, "", append([]string{"-l"}, tt.args...)...)
+		if code != 0 || stderr != "" {
+			t.Fatalf("%q: exit status %d, stderr:\n%s", tt.args, code, stderr)
+		}
+		if stdout != tt.want {
+			t.Errorf("%q: listed:\n%s\nwant:\n%s", tt.args, stdout, tt.want)
+		}
+	}
+}
+
+// TestWalkConstraints checks that walked files are skipped if excluded by
+// build constraints for the target GOOS.
+func TestWalkConstraints(t *testing.T) {
+	moduleEnv(t)
+	chdir(t, t.TempDir())
+	writeFile(t, "go.mod", "module example.com/walk\n\ngo 1.22\n")
+	writeFile(t, "a.go", "package x\n\ntype T struct{ A, B int }\n\nvar t = T{1, 2}\n")
+	for _, goos := range []string{"linux", "windows"} {
+		writeFile(t, "a_"+goos+".go", "package x\n\nvar "+goos+" = T{1, 2}\n")
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-goos", "linux", "."}, "a.go\na_linux.go\n"},
+		{[]string{"-goos", "windows", "."}, "a.go\na_windows.go\n"},
+		{[]string{"-goos", "windows", "a_linux.go"}, "a_linux.go\n"},
+	} {
+		stdout, stderr, code := run(t, "", append([]string{"-l"}, tt.args...)...)
+		if code != 0 || stderr != "" {
+			t.Fatalf("%q: exit status %d, stderr:\n%s", tt.args, code, stderr)
+		}
+		if stdout != tt.want {
+			t.Errorf("%q: listed:\n%s\nwant:\n%s", tt.args, stdout, tt.want)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	if _, err := exec.LookPath("diff"); err != nil {
+		t.Skip("no diff command")
+	}
+	moduleEnv(t)
+	dir := fixture(t, "calls")
+	chdir(t, copyFixture(t, dir))
+
+	stdout, stderr, code := run(t, "", "diff", "a.go")
+	if code != 0 || stderr != "" {
+		t.Fatalf("exit status %d, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"--- a.go.orig\n+++ a.go\n",
+		"\n-func point() P { return P{1, 2} }\n",
+		"\n+func point() P { return P{X: 1, Y: 2} }\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("diff lacks %q:\n%s", want, stdout)
+		}
+	}
+	// Displaying diffs leaves the files alone.
+	checkUnchanged(t, dir, ".")
+}
+
+// TestFilesFrom lists the files to fix, saves the list and has it fixed with
+// -files-from, after which there's nothing left to list.
+func TestFilesFrom(t *testing.T) {
+	moduleEnv(t)
+	fixtures := fixture(t, "")
+	chdir(t, copyFixture(t, fixtures))
+
+	listed, stderr, code := run(t, "", "list", ".")
+	if code != 0 || listed == "" || stderr != "" {
+		t.Fatalf("list: exit status %d, stdout:\n%s\nstderr:\n%s", code, listed, stderr)
+	}
+	writeFile(t, "changes.txt", listed)
+	stdout, stderr, code := run(t, "", "fix", "-files-from", "changes.txt")
+	if code != 0 || stdout != "" || stderr != "" {
+		t.Fatalf("fix: exit status %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+	checkGolden(t, fixtures, ".")
+
+	// An empty list means nothing to fix, rather than stdin.
+	writeFile(t, "changes.txt", "")
+	stdout, stderr, code = run(t, "", "fix", "-files-from", "changes.txt")
+	if code != 0 || stdout != "" || stderr != "" {
+		t.Errorf("empty list: exit status %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+	if stdout, _, _ := run(t, "", "list", "."); stdout != "" {
+		t.Errorf("listed after fixing:\n%s", stdout)
+	}
+}
+
+// TestRepeatedFiles gives the same file in several ways, which must only be
+// processed the first time.
+func TestRepeatedFiles(t *testing.T) {
+	tmp := t.TempDir()
+	chdir(t, tmp)
+	const src = "package x\n\ntype T struct{ A, B int }\n\nvar t = T{1, 2}\n"
+	writeFile(t, "a.go", src)
+	if err := os.Symlink("a.go", "link.go"); err != nil {
+		t.Skip("can't make symlinks:", err)
+	}
+
+	args := []string{"a.go", "./a.go", filepath.Join(tmp, "a.go"), ".", "link.go"}
+	stdout, stderr, code := run(t, "", append([]string{"-l"}, args...)...)
+	if code != 0 || stderr != "" {
+		t.Fatalf("-l: exit status %d, stderr:\n%s", code, stderr)
+	}
+	if want := "a.go\n"; stdout != want {
+		t.Errorf("listed:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	stdout, stderr, code = run(t, "", append([]string{"-w", "-summary-json", "s.json"}, args...)...)
+	if code != 0 || stdout != "" || stderr != "" {
+		t.Fatalf("-w: exit status %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+	if got, want := readFile(t, "a.go"), "package x\n\ntype T struct{ A, B int }\n\nvar t = T{A: 1, B: 2}\n"; got != want {
+		t.Errorf("fixed:\n%s\nwant:\n%s", got, want)
+	}
+	var summary runSummary
+	if err := json.Unmarshal([]byte(readFile(t, "s.json")), &summary); err != nil || summary.FilesChanged != 1 {
+		t.Errorf("summary %+v, %v; want 1 file changed", summary, err)
+	}
+}
+
+// TestMatch checks that -match limits fixing to the files whose slashed paths
+// it matches, leaving the rest untouched.
+func TestMatch(t *testing.T) {
+	chdir(t, t.TempDir())
+	files := map[string]string{
+		"a.go":          "package x\n\ntype T struct{ A, B int }\n\nvar t = T{1, 2}\n",
+		"a_test.go":     "package x\n\nvar u = T{3, 4}\n",
+		"sub/b.go":      "package x\n\ntype T struct{ A, B int }\n\nvar t = T{1, 2}\n",
+		"sub/b_test.go": "package x\n\nvar u = T{3, 4}\n",
+	}
+	for path, src := range files {
+		writeFile(t, path, src)
+	}
+
+	stdout, stderr, code := run(t, "", "-w", "-l", "-match", `^sub/.*_test\.go$`, ".")
+	if code != 0 || stderr != "" {
+		t.Fatalf("exit status %d, stderr:\n%s", code, stderr)
+	}
+	if want := "sub/b_test.go\n"; stdout != want {
+		t.Errorf("listed:\n%s\nwant:\n%s", stdout, want)
+	}
+	for path, src := range files {
+		if got := readFile(t, path); path != "sub/b_test.go" && got != src {
+			t.Errorf("%s changed:\n%s", path, got)
+		}
+	}
+	if got, want := readFile(t, "sub/b_test.go"), "package x\n\nvar u = T{A: 3, B: 4}\n"; got != want {
+		t.Errorf("sub/b_test.go:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestIgnoreFile checks that files matched by the .gofixignore file at the
+// module's root are skipped when walking directories, but not when given.
+func TestIgnoreFile(t *testing.T) {
+	moduleEnv(t)
+	chdir(t, t.TempDir())
+	writeFile(t, "go.mod", "module example.com/ignore\n\ngo 1.22\n")
+	writeFile(t, ".gofixignore", "# Generated.\n*.pb.go\n!keep.pb.go\ninternal/legacy/\n/root.go\ngen/**/z?.go\n")
+	paths := []string{
+		"a.go", "x.pb.go", "sub/y.pb.go", "sub/keep.pb.go", "internal/legacy/l.go", "internal/legacy/old/o.go",
+		"root.go", "sub/root.go", "gen/z1.go", "gen/a/b/z2.go", "gen/a/w.go",
+	}
+	for i, path := range paths {
+		writeFile(t, path, fmt.Sprintf("package x\n\ntype T%d struct{ A, B int }\n\nvar t%d = T%d{1, 2}\n", i, i, i))
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"."}, "a.go\ngen/a/w.go\nsub/keep.pb.go\nsub/root.go\n"},
+		{[]string{"internal/legacy"}, ""},
+		{[]string{"x.pb.go", "internal/legacy/l.go"}, "x.pb.go\ninternal/legacy/l.go\n"},
+	} {
+		stdout, stderr, code := run(t, "", append([]string{"-l"}, tt.args...)...)
+		if code != 0 || stderr != "" {
+			t.Fatalf("%q: exit status %d, stderr:\n%s", tt.args, code, stderr)
+		}
+		if stdout != tt.want {
+			t.Errorf("%q: listed:\n%s\nwant:\n%s", tt.args, stdout, tt.want)
+		}
+	}
+
+	writeFile(t, ".gofixignore", "[z-a].go\n")
+	if _, stderr, code := run(t, "", "-l", "."); code != 1 || !strings.Contains(stderr, ".gofixignore: line 1: ") {
+		t.Errorf("invalid pattern: exit status %d, stderr:\n%s", code, stderr)
+	}
+}
+
+// TestGenericAlias fixes literals of generic alias types, instantiated there
+// or through another alias, which go1.24 added.
+func TestGenericAlias(t *testing.T) {
+	if v := runtime.Version(); version.IsValid(v) && version.Compare(v, "go1.24") < 0 {
+		t.Skip("no generic aliases before go1.24, running", v)
+	}
+	moduleEnv(t)
+	chdir(t, t.TempDir())
+	writeFile(t, "go.mod", "module example.com/alias\n\ngo 1.24\n")
+	const src = "package x\n\ntype Pair[T any] struct{ A, B T }\n\ntype P[T any] = Pair[T]\n\ntype IntP = P[int]\n\nvar (\n\ta = P[string]{%s}\n\tb = IntP{%s}\n\tc = []P[int]{{%s}}\n)\n"
+	writeFile(t, "a.go", fmt.Sprintf(src, `"a", "b"`, "1, 2", "3, 4"))
+
+	stdout, stderr, code := run(t, "", "a.go")
+	if code != 0 || stderr != "" {
+		t.Fatalf("exit status %d, stderr:\n%s", code, stderr)
+	}
+	if want := fmt.Sprintf(src, `A: "a", B: "b"`, "A: 1, B: 2", "A: 3, B: 4"); stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+// TestNoPackageClause checks that a file without a package clause is
+// reported as such, while the other files are still fixed.
+func TestNoPackageClause(t *testing.T) {
+	chdir(t, t.TempDir())
+	const src = "package x\n\ntype T struct{ A, B int }\n\nvar t = T{1, 2}\n"
+	writeFile(t, "a/a.go", src)
+	writeFile(t, "b/b.go", "type T struct{ A, B int }\n\nvar t = T{1, 2}\n")
+	writeFile(t, "c/c.go", src)
+
+	stdout, stderr, code := run(t, "", "-w", "-l", ".")
+	if code != 1 || stdout != "a/a.go\nc/c.go\n" {
+		t.Errorf("exit status %d, stdout:\n%s", code, stdout)
+	}
+	if want := "b/b.go:1:1: expected 'package', found 'type'\n"; stderr != want {
+		t.Errorf("stderr:\n%s\nwant:\n%s", stderr, want)
+	}
+	for _, path := range []string{"a/a.go", "c/c.go"} {
+		if got := readFile(t, path); got == src {
+			t.Errorf("%s wasn't fixed", path)
+		}
+	}
+}
+
+// TestImportPrefix checks that -import-prefix limits fixing to the packages
+// whose import paths start with it, leaving the others untouched.
+func TestImportPrefix(t *testing.T) {
+	moduleEnv(t)
+	chdir(t, t.TempDir())
+	writeFile(t, "go.mod", "module example.com/mono\n\ngo 1.22\n")
+	const src = "package x\n\ntype T struct{ A, B int }\n\nvar t = T{1, 2}\n"
+	paths := []string{"a.go", "internal/b/b.go", "internal/b/c/c.go", "internalx/d.go", "pkg/e.go"}
+	for _, path := range paths {
+		writeFile(t, path, src)
+	}
+
+	stdout, stderr, code := run(t, "", "-w", "-l", "-import-prefix", "example.com/mono/internal/", ".")
+	if code != 0 || stderr != "" {
+		t.Fatalf("exit status %d, stderr:\n%s", code, stderr)
+	}
+	if want := "internal/b/b.go\ninternal/b/c/c.go\n"; stdout != want {
+		t.Errorf("listed:\n%s\nwant:\n%s", stdout, want)
+	}
+	for _, path := range paths {
+		if fixed := readFile(t, path) != src; fixed != strings.HasPrefix(path, "internal/") {
+			t.Errorf("%s fixed: %v", path, fixed)
+		}
+	}
+}
+
+// TestPkgDir fixes stdin and -e as new files of the package in -pkgdir, whose
+// files give the types, without touching them.
+func TestPkgDir(t *testing.T) {
+	moduleEnv(t)
+	chdir(t, t.TempDir())
+	writeFile(t, "go.mod", "module example.com/pkgdir\n\ngo 1.22\n")
+	const typ = "package p\n\ntype T struct{ A, B int }\n\nvar t = T{1, 2}\n"
+	writeFile(t, "p/t.go", typ)
+
+	stdout, stderr, code := run(t, "package p\n\nvar x = T{3, 4}\n", "-pkgdir", "p")
+	if code != 0 || stderr != "" {
+		t.Fatalf("stdin: exit status %d, stderr:\n%s", code, stderr)
+	}
+	if want := "package p\n\nvar x = T{A: 3, B: 4}\n"; stdout != want {
+		t.Errorf("stdin:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	stdout, stderr, code = run(t, "", "-pkgdir", "p", "-e", "var y = []T{{5, 6}}")
+	if code != 0 || stderr != "" {
+		t.Fatalf("-e: exit status %d, stderr:\n%s", code, stderr)
+	}
+	if want := "var y = []T{{A: 5, B: 6}}\n"; stdout != want {
+		t.Errorf("-e:\n%s\nwant:\n%s", stdout, want)
+	}
+	if got := readFile(t, "p/t.go"); got != typ {
+		t.Errorf("p/t.go changed:\n%s", got)
+	}
+
+	for _, dir := range []string{"nope", "p/t.go"} {
+		if _, stderr, code := run(t, "", "-pkgdir", dir); code != 1 || stderr == "" {
+			t.Errorf("-pkgdir %s: exit status %d, stderr:\n%s", dir, code, stderr)
+		}
+	}
+}
+
+// TestStdinFilename fixes an editor's buffer that differs from the file it's
+// named after, which must be type-checked as in the buffer, with the rest of
+// the package as on disk.
+func TestStdinFilename(t *testing.T) {
+	moduleEnv(t)
+	chdir(t, t.TempDir())
+	writeFile(t, "go.mod", "module example.com/buffer\n\ngo 1.22\n")
+	const saved = "package p\n\ntype T struct{ A, B int }\n\nvar t = T{1, 2}\n"
+	writeFile(t, "p/a.go", saved)
+	writeFile(t, "p/b.go", "package p\n\ntype U struct{ C, D int }\n")
+
+	const buffer = "package p\n\ntype T struct{ X, Y, Z int }\n\nvar (\n\tt = T{%s}\n\tu = U{%s}\n)\n"
+	stdout, stderr, code := run(t, fmt.Sprintf(buffer, "1, 2, 3", "4, 5"), "-stdin-filename", "p/a.go")
+	if code != 0 || stderr != "" {
+		t.Fatalf("exit status %d, stderr:\n%s", code, stderr)
+	}
+	if want := fmt.Sprintf(buffer, "X: 1, Y: 2, Z: 3", "C: 4, D: 5"); stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+	if got := readFile(t, "p/a.go"); got != saved {
+		t.Errorf("p/a.go changed:\n%s", got)
+	}
+
+	// Buffers of files not saved yet are new files of their package.
+	stdout, stderr, code = run(t, "package p\n\nvar v = U{6, 7}\n", "-stdin-filename", "p/new.go")
+	if want := "package p\n\nvar v = U{C: 6, D: 7}\n"; code != 0 || stderr != "" || stdout != want {
+		t.Errorf("new file: exit status %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+}
+
+// TestNoPackages checks the errors for directories without any package to
+// fix: one for an empty directory, and those parsing the files for one with
+// only broken files, while the other directories are still fixed.
+func TestNoPackages(t *testing.T) {
+	chdir(t, t.TempDir())
+	if err := os.MkdirAll("empty", 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, "broken/a.go", "nope\n")
+	writeFile(t, "broken/b.go", "package\n")
+	writeFile(t, "ok/a.go", "package x\n\ntype T struct{ A, B int }\n\nvar t = T{1, 2}\n")
+
+	for _, tt := range []struct {
+		args           []string
+		stdout, stderr string
+	}{
+		{[]string{"empty", "ok"}, "ok/a.go\n", "no Go files found in empty\n"},
+		{[]string{"broken", "ok"}, "ok/a.go\n", "broken/a.go:1:1: expected 'package', found nope\nbroken/b.go:1:9: expected 'IDENT', found 'EOF'\n"},
+	} {
+		stdout, stderr, code := run(t, "", append([]string{"-l"}, tt.args...)...)
+		if code != 1 || stdout != tt.stdout || stderr != tt.stderr {
+			t.Errorf("%q: exit status %d, stdout:\n%s\nstderr:\n%s\nwant stdout:\n%s\nstderr:\n%s", tt.args, code, stdout, stderr, tt.stdout, tt.stderr)
+		}
+	}
+}
+
+// TestTags fixes the tagged fixture with its tag set, which must select its
+// tagged files instead, types from them included.
+func TestTags(t *testing.T) {
+	moduleEnv(t)
+	dir := fixture(t, "tagged")
+	chdir(t, copyFixture(t, dir))
+
+	stdout, stderr, code := run(t, "", "fix", "-tags", "integration", "-l", ".")
+	if code != 0 || stderr != "" {
+		t.Fatalf("exit status %d, stderr:\n%s", code, stderr)
+	}
+	if want := "a.go\nuse_integration.go\n"; stdout != want {
+		t.Errorf("listed:\n%s\nwant:\n%s", stdout, want)
+	}
+	if got, want := readFile(t, "use_integration.go"), "//go:build integration\n\npackage tagged\n\nvar (\n\te  = Extra{P: \"p\", Q: \"q\"}\n\tb2 = Base{X: 3, Y: 4}\n)\n"; got != want {
+		t.Errorf("use_integration.go:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := readFile(t, "plain_other.go"), readFile(t, filepath.Join(dir, "plain_other.go")); got != want {
+		t.Errorf("plain_other.go changed:\n%s", got)
+	}
+}
+
+// TestGitDiff checks that -git-diff names files relative to the current
+// directory, or -root, however they're given, and that git apply takes it.
+func TestGitDiff(t *testing.T) {
+	for _, cmd := range []string{"diff", "git"} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			t.Skipf("no %s command", cmd)
+		}
+	}
+	moduleEnv(t)
+	dir := fixture(t, "calls")
+	tmp := copyFixture(t, dir)
+	chdir(t, tmp)
+
+	for _, tt := range []struct {
+		args      []string
+		dir, name string
+	}{
+		{[]string{"a.go"}, ".", "a.go"},
+		{[]string{filepath.Join(tmp, "a.go")}, ".", "a.go"},
+		{[]string{"-root", "..", filepath.Join(tmp, "a.go")}, "..", filepath.Base(tmp) + "/a.go"},
+	} {
+		stdout, stderr, code := run(t, "", append([]string{"-git-diff"}, tt.args...)...)
+		if code != 0 || stderr != "" {
+			t.Fatalf("%q: exit status %d, stderr:\n%s", tt.args, code, stderr)
+		}
+		if want := fmt.Sprintf("diff --git a/%s b/%s\n--- a/%s\n+++ b/%s\n", tt.name, tt.name, tt.name, tt.name); !strings.HasPrefix(stdout, want) {
+			t.Errorf("%q: diff doesn't start with %q:\n%s", tt.args, want, stdout)
+		}
+		cmd := exec.Command("git", "apply", "--check", "-")
+		cmd.Dir = tt.dir
+		cmd.Stdin = strings.NewReader(stdout)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("%q: git apply --check: %s\n%s", tt.args, err, out)
+		}
+	}
+
+	// Files outside the directory can't be named.
+	writeFile(t, "sub/x", "")
+	chdir(t, "sub")
+	stdout, stderr, code := run(t, "", "-git-diff", "../a.go")
+	if code != 1 || stdout != "" || !strings.HasPrefix(stderr, "../a.go: outside ") {
+		t.Errorf("outside: exit status %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+	checkUnchanged(t, dir, tmp)
+}
+
+func TestCheck(t *testing.T) {
+	moduleEnv(t)
+	fixtures := fixture(t, "")
+	chdir(t, copyFixture(t, fixtures))
+
+	stdout, stderr, code := run(t, "", "check", "nested")
+	if code != 1 || stderr != "" {
+		t.Fatalf("exit status %d, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"nested/a.go:13:6: A struct literal uses unkeyed fields\n",
+		"nested/a.go:13:13: example.com/nested/c.D struct literal uses unkeyed fields\n",
+		"nested/a.go:15:15: example.com/nested/c.D struct literal uses unkeyed fields\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("report lacks %q:\n%s", want, stdout)
+		}
+	}
+	checkUnchanged(t, filepath.Join(fixtures, "nested"), "nested")
+
+	// Only the exit status tells with -quiet.
+	stdout, stderr, code = run(t, "", "check", "-quiet", "nested")
+	if code != 1 || stdout != "" || stderr != "" {
+		t.Errorf("-quiet: exit status %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+	stdout, stderr, code = run(t, "", "-quiet", "-verify-keyed", "example.com/skipped.P", "skipped")
+	if code != 1 || stdout != "" || stderr != "" {
+		t.Errorf("-quiet -verify-keyed: exit status %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+
+	stdout, stderr, code = run(t, "", "check", "skipped")
+	if code != 0 || stdout != "" || stderr != "" {
+		t.Errorf("keyed literals: exit status %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+}
+
+// TestInaccessibleFields checks that literals of struct types from other
+// packages with unexported fields are left alone, as keys can't name those
+// fields, while the literals nested in them are still keyed. Such literals
+// don't compile, so there's no fixture for them in testdata/fix.
+func TestInaccessibleFields(t *testing.T) {
+	moduleEnv(t)
+	chdir(t, t.TempDir())
+	writeFile(t, "go.mod", "module example.com/inaccessible\n\ngo 1.22\n")
+	writeFile(t, "other/other.go", "package other\n\ntype Outer struct {\n\tIn Inner\n\tn  int\n}\n\ntype Inner struct{ A, B int }\n")
+	writeFile(t, "a.go", "package a\n\nimport \"example.com/inaccessible/other\"\n\nvar o = other.Outer{other.Inner{1, 2}, 3}\n")
+
+	stdout, stderr, code := run(t, "", "a.go")
+	if code != 0 || stderr != "" {
+		t.Fatalf("exit status %d, stderr:\n%s", code, stderr)
+	}
+	if want := "package a\n\nimport \"example.com/inaccessible/other\"\n\nvar o = other.Outer{other.Inner{A: 1, B: 2}, 3}\n"; stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+// TestInvalidTypes checks that the literals of broken code that go/types
+// gives an invalid type are skipped for lack of type, while the rest of the
+// file is still fixed.
+func TestInvalidTypes(t *testing.T) {
+	chdir(t, t.TempDir())
+	const src = "package x\n\nimport \"nope/y\"\n\ntype P struct{ X, Y int }\n\nvar (\n\ta = Undefined{1, 2}\n\tb = y.T{1, 2}\n\tc = []Undefined{{1, 2}}\n\td = P{1, 2, 3}\n\te = P{%s}\n)\n"
+
+	stdout, stderr, code := run(t, fmt.Sprintf(src, "1, 2"), "-summary-json", "s.json")
+	if code != 0 || stderr != "" {
+		t.Fatalf("exit status %d, stderr:\n%s", code, stderr)
+	}
+	if want := fmt.Sprintf(src, "X: 1, Y: 2"); stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+	var summary runSummary
+	if err := json.Unmarshal([]byte(readFile(t, "s.json")), &summary); err != nil || summary.LiteralsSkipped[unkeyed.SkipNoType] != 3 {
+		t.Errorf("summary %+v, %v; want 3 literals skipped for lack of type", summary, err)
+	}
+}
+
+// TestMixedWarning checks that literals mixing keyed and unkeyed elements,
+// which don't compile but may be in files excluded by build constraints, are
+// left alone with a warning saying so.
+func TestMixedWarning(t *testing.T) {
+	chdir(t, t.TempDir())
+	const src = "package x\n\ntype P struct{ X, Y int }\n\nvar (\n\tp = P{1, Y: 2}\n\tq = P{3, 4}\n)\n"
+	writeFile(t, "a.go", src)
+
+	stdout, stderr, code := run(t, "", "a.go")
+	if code != 0 {
+		t.Fatalf("exit status %d, stderr:\n%s", code, stderr)
+	}
+	if want := strings.Replace(src, "P{3, 4}", "P{X: 3, Y: 4}", 1); stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+	if want := "a.go:6:6: struct literal mixes keyed and unkeyed fields; not adding keys\n"; stderr != want {
+		t.Errorf("stderr:\n%s\nwant:\n%s", stderr, want)
+	}
+}
+
+// TestPointers checks that literals with elided & are reported by the types
+// they point to.
+func TestPointers(t *testing.T) {
+	moduleEnv(t)
+	chdir(t, copyFixture(t, fixture(t, "")))
+
+	stdout, stderr, code := run(t, "", "-types", "pointers")
+	if code != 0 || stderr != "" {
+		t.Fatalf("-types: exit status %d, stderr:\n%s", code, stderr)
+	}
+	if want := "6\texample.com/pointers.P\n"; stdout != want {
+		t.Errorf("-types:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	stdout, stderr, code = run(t, "", "check", "pointers")
+	if code != 1 || stderr != "" {
+		t.Fatalf("check: exit status %d, stderr:\n%s", code, stderr)
+	}
+	if want := "pointers/a.go:9:12: P struct literal uses unkeyed fields\n"; !strings.Contains(stdout, want) {
+		t.Errorf("check report lacks %q:\n%s", want, stdout)
+	}
+	if strings.Contains(stdout, "*P") {
+		t.Errorf("check report has pointer types:\n%s", stdout)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"-h"}, 0},
+		{[]string{"-nope"}, 2},
+		{[]string{"-w", "-e", "var x = 1"}, 1},
+		{[]string{"-add-header", "-e", "var x = 1"}, 1},
+		{[]string{"-minimal-diff", "-no-format", "."}, 1},
+	} {
+		_, stderr, code := run(t, "", tt.args...)
+		if code != tt.code {
+			t.Errorf("%q: exit status %d, want %d; stderr:\n%s", tt.args, code, tt.code, stderr)
+		}
+	}
+}
+
+// TestCgo checks that packages using cgo are imported without a C toolchain,
+// and that failing imports don't affect others, whatever the order files are
+// fixed in.
+func TestCgo(t *testing.T) {
+	moduleEnv(t)
+	tmp := t.TempDir()
+	writeFile(t, filepath.Join(tmp, "go.mod"), "module example.com/cgo\n\ngo 1.22\n")
+	writeFile(t, filepath.Join(tmp, "c", "c.go"), "package c\n\nimport \"C\"\n\ntype T struct{ A, B int }\n")
+	writeFile(t, filepath.Join(tmp, "u", "a.go"), "package u\n\nimport \"example.com/cgo/c\"\n\nvar t = c.T{1, 2}\n")
+	writeFile(t, filepath.Join(tmp, "u", "b.go"), "package u\n\nimport _ \"nope/x\"\n")
+	chdir(t, tmp)
+
+	cgo := build.Default.CgoEnabled
+	for _, files := range [][]string{{"u/b.go", "u/a.go"}, {"u/a.go", "u/b.go"}} {
+		stdout, stderr, code := run(t, "", append([]string{"-concurrency", "0", "-l"}, files...)...)
+		if code != 0 || stderr != "" {
+			t.Fatalf("%q: exit status %d, stderr:\n%s", files, code, stderr)
+		}
+		if want := "u/a.go\n"; stdout != want {
+			t.Errorf("%q: listed:\n%s\nwant:\n%s", files, stdout, want)
+		}
+	}
+	if build.Default.CgoEnabled != cgo {
+		t.Errorf("build.Default.CgoEnabled changed to %v", build.Default.CgoEnabled)
+	}
+}
+
+// TestHTTPCookie fixes a literal of a type from net/http, which is only
+// imported if its cgo files are.
+func TestHTTPCookie(t *testing.T) {
+	chdir(t, t.TempDir())
+	typ := reflect.TypeOf(http.Cookie{})
+	var values, keyed []string
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		zero := fmt.Sprintf("*new(%s)", field.Type)
+		values = append(values, zero)
+		keyed = append(keyed, field.Name+": "+zero)
+	}
+	const src = "package main\n\nimport (\n\t\"net/http\"\n\t\"time\"\n)\n\nvar _ time.Time\n\nvar c = http.Cookie{%s}\n"
+	in := fmt.Sprintf(src, strings.Join(values, ", "))
+	stdout, stderr, code := run(t, in)
+	if code != 0 || stderr != "" {
+		t.Fatalf("exit status %d, stderr:\n%s", code, stderr)
+	}
+	if want := fmt.Sprintf(src, strings.Join(keyed, ", ")); stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+// TestFailFast checks that -fail-fast stops at the first failing file, but
+// still writes the reports of what was done up to it.
+func TestFailFast(t *testing.T) {
+	const src = "package x\n\ntype T struct{ A, B int }\n\nvar t = T{1, 2}\n"
+	for _, concurrency := range []string{"0", "4"} {
+		chdir(t, t.TempDir())
+		writeFile(t, "a/a.go", src)
+		writeFile(t, "b/b.go", "not Go\n")
+		writeFile(t, "c/c.go", src)
+
+		stdout, stderr, code := run(t, "", "-w", "-fail-fast", "-concurrency", concurrency, "-report", "r.json", "-summary-json", "s.json", ".")
+		if code != 1 || stdout != "" || !strings.HasPrefix(stderr, "b/b.go:1:1: ") {
+			t.Errorf("-concurrency %s: exit status %d, stdout:\n%s\nstderr:\n%s", concurrency, code, stdout, stderr)
+		}
+		if readFile(t, "a/a.go") == src || readFile(t, "c/c.go") != src {
+			t.Errorf("-concurrency %s: fixed a/a.go:\n%s\nc/c.go:\n%s", concurrency, readFile(t, "a/a.go"), readFile(t, "c/c.go"))
+		}
+		var changes []struct{ File string }
+		if err := json.Unmarshal([]byte(readFile(t, "r.json")), &changes); err != nil || len(changes) != 1 || changes[0].File != "a/a.go" {
+			t.Errorf("-concurrency %s: report %v, %v", concurrency, changes, err)
+		}
+		var summary runSummary
+		if err := json.Unmarshal([]byte(readFile(t, "s.json")), &summary); err != nil || summary.FilesChanged != 1 || len(summary.Errors) != 1 {
+			t.Errorf("-concurrency %s: summary %+v, %v", concurrency, summary, err)
+		}
+	}
+}
+
+// TestFailFastGoroutines checks that stopping early leaves nothing running,
+// neither the goroutine starting the fixing of files nor the ones fixing them.
+func TestFailFastGoroutines(t *testing.T) {
+	chdir(t, t.TempDir())
+	writeFile(t, "a/a.go", "not Go\n")
+	for i := 0; i < 20; i++ {
+		writeFile(t, fmt.Sprintf("b%d/b.go", i), "package x\n\ntype T struct{ A, B int }\n\nvar t = T{1, 2}\n")
+	}
+
+	before := runtime.NumGoroutine()
+	if _, stderr, code := run(t, "", "-l", "-fail-fast", "-concurrency", "2", "."); code != 1 {
+		t.Fatalf("exit status %d, stderr:\n%s", code, stderr)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%d goroutines left running", after-before)
+	}
+}
+
+// TestConcurrent fixes many files at once, to be run with -race.
+func TestConcurrent(t *testing.T) {
+	moduleEnv(t)
+	tmp := t.TempDir()
+	writeFile(t, filepath.Join(tmp, "go.mod"), "module example.com/many\n\ngo 1.22\n")
+	writeFile(t, filepath.Join(tmp, "p", "p.go"), "package p\n\ntype P struct{ X, Y int }\n")
+	const n = 24
+	for i := 0; i < n; i++ {
+		src := fmt.Sprintf("package pkg%d\n\nimport \"example.com/many/p\"\n\ntype T struct{ A, B int }\n\nvar (\n\tt = T{%d, 2}\n\tu = []p.P{{%d, 2}}\n)\n", i, i, i)
+		writeFile(t, filepath.Join(tmp, fmt.Sprintf("pkg%d", i), "a.go"), src)
+	}
+
+	stdout, stderr, code := run(t, "", "-w", "-l", "-debug", "-concurrency", "4", tmp)
+	if code != 0 {
+		t.Fatalf("exit status %d, stderr:\n%s", code, stderr)
+	}
+	var listed []string
+	for i := 0; i < n; i++ {
+		path := filepath.Join(tmp, fmt.Sprintf("pkg%d", i), "a.go")
+		listed = append(listed, path)
+		want := fmt.Sprintf("package pkg%d\n\nimport \"example.com/many/p\"\n\ntype T struct{ A, B int }\n\nvar (\n\tt = T{A: %d, B: 2}\n\tu = []p.P{{X: %d, Y: 2}}\n)\n", i, i, i)
+		if got := readFile(t, path); got != want {
+			t.Errorf("%s:\n%s\nwant:\n%s", path, got, want)
+		}
+	}
+	// In the order they're walked.
+	sort.Strings(listed)
+	if want := strings.Join(listed, "\n") + "\n"; stdout != want {
+		t.Errorf("listed:\n%s\nwant:\n%s", stdout, want)
+	}
+	// Debugging output is in that order too, with whole lines, for every
+	// file.
+	dep := filepath.Join(tmp, "p", "p.go")
+	debugged := []string{dep + ": package p ", dep + ": 0 composite literals"}
+	for _, path := range listed {
+		debugged = append(debugged, path+": package ", path+": 3 composite literals")
+	}
+	lines := strings.Split(strings.TrimSuffix(stderr, "\n"), "\n")
+	if len(lines) != len(debugged) {
+		t.Fatalf("debugging output:\n%s\nwant %d lines", stderr, len(debugged))
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, debugged[i]) {
+			t.Errorf("debugging output line %d is %q, want it to start with %q", i+1, line, debugged[i])
+		}
+	}
+}
+
+// TestReentrant runs the command concurrently with different build settings,
+// which must neither interfere nor be left behind.
+func TestReentrant(t *testing.T) {
+	moduleEnv(t)
+	t.Setenv("GO111MODULE", "")
+	chdir(t, copyFixture(t, fixture(t, "")))
+	saved := build.Default
+
+	argss := [][]string{
+		{"-tags", "a,b", "-goos", "windows", "-goarch", "386"},
+		{"-tags", "c", "-goos", "darwin", "-goarch", "arm64"},
+	}
+	type output struct {
+		stdout, stderr string
+		code           int
+	}
+	outputs := make([]output, len(argss))
+	var wg sync.WaitGroup
+	for i, args := range argss {
+		wg.Add(1)
+		go func(i int, args []string) {
+			defer wg.Done()
+			var out, errOut bytes.Buffer
+			code := Run(append(args, "-l", "nested"), strings.NewReader(""), &out, &errOut)
+			outputs[i] = output{out.String(), errOut.String(), code}
+		}(i, args)
+	}
+	wg.Wait()
+	for i, out := range outputs {
+		if out.code != 0 || out.stdout != "nested/a.go\n" || out.stderr != "" {
+			t.Errorf("%q: exit status %d, stdout:\n%s\nstderr:\n%s", argss[i], out.code, out.stdout, out.stderr)
+		}
+	}
+
+	if got, want := fmt.Sprint(build.Default.BuildTags, build.Default.GOOS, build.Default.GOARCH, build.Default.CgoEnabled, build.Default.Dir),
+		fmt.Sprint(saved.BuildTags, saved.GOOS, saved.GOARCH, saved.CgoEnabled, saved.Dir); got != want {
+		t.Errorf("build.Default changed to %s, from %s", got, want)
+	}
+	if v, ok := os.LookupEnv("GO111MODULE"); !ok || v != "" {
+		t.Errorf("GO111MODULE changed to %q", v)
+	}
+}
+
+// TestLayouts checks that packages and their dependencies are found, and
+// named, both in a module and in GOPATH.
+func TestLayouts(t *testing.T) {
+	gopath := build.Default.GOPATH
+	t.Cleanup(func() { build.Default.GOPATH = gopath })
+	const (
+		src = "package %s\n\nimport \"%s/dep\"\n\ntype T struct{ A, B int }\n\nvar (\n\tt = T{1, 2}\n\td = dep.D{3, 4}\n)\n"
+		dep = "package dep\n\ntype D struct{ X, Y int }\n"
+	)
+
+	for _, layout := range []string{"module", "GOPATH"} {
+		tmp := t.TempDir()
+		var dir, pkgPath string
+		if layout == "module" {
+			moduleEnv(t)
+			writeFile(t, filepath.Join(tmp, "go.mod"), "module example.com/mod\n\ngo 1.22\n")
+			dir, pkgPath = filepath.Join(tmp, "sub"), "example.com/mod/sub"
+			writeFile(t, filepath.Join(tmp, "sub", "dep", "d.go"), dep)
+		} else {
+			t.Setenv("GO111MODULE", "off")
+			build.Default.GOPATH = tmp
+			dir, pkgPath = filepath.Join(tmp, "src", "example.com", "gp"), "example.com/gp"
+			writeFile(t, filepath.Join(dir, "dep", "d.go"), dep)
+		}
+		writeFile(t, filepath.Join(dir, "a.go"), fmt.Sprintf(src, filepath.Base(dir), pkgPath))
+		chdir(t, dir)
+
+		stdout, stderr, code := run(t, "", "-types", ".")
+		if code != 0 || stderr != "" {
+			t.Fatalf("%s: exit status %d, stderr:\n%s", layout, code, stderr)
+		}
+		if want := fmt.Sprintf("1\t%s.T\n1\t%s/dep.D\n", pkgPath, pkgPath); stdout != want {
+			t.Errorf("%s: types:\n%s\nwant:\n%s", layout, stdout, want)
+		}
+	}
+}
+
+// TestDeterministic diffs all the fixtures over and over, which must print the
+// same every time, keys and files alike.
+func TestDeterministic(t *testing.T) {
+	moduleEnv(t)
+	chdir(t, copyFixture(t, fixture(t, "")))
+
+	want, stderr, code := run(t, "", "-d", ".")
+	if code != 0 || want == "" || stderr != "" {
+		t.Fatalf("exit status %d, stdout:\n%s\nstderr:\n%s", code, want, stderr)
+	}
+	for i := 0; i < 4; i++ {
+		if got, _, _ := run(t, "", "-d", "-concurrency", "4", "."); got != want {
+			t.Fatalf("run %d printed:\n%s\nwant:\n%s", i+2, got, want)
+		}
+	}
+}
+
+// run runs the command with args and stdin as its input, and returns what it
+// printed and its exit status.
+func run(t *testing.T, stdin string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	code = Run(args, strings.NewReader(stdin), &out, &errOut)
+	return out.String(), errOut.String(), code
+}
+
+// fixture returns the absolute path of the fixture name in testdata/fix, which
+// stays valid once a test changes directory.
+func fixture(t *testing.T, name string) string {
+	t.Helper()
+	dir, err := filepath.Abs(filepath.Join("testdata", "fix", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// moduleEnv makes sure the fixtures, which are modules, are loaded in module
+// mode, whatever the environment the tests are run in.
+func moduleEnv(t *testing.T) {
+	t.Setenv("GO111MODULE", "on")
+	t.Setenv("GOFLAGS", "")
+}
+
+// copyFixture copies the directory tree dir to a temporary directory, and
+// returns the copy.
+func copyFixture(t *testing.T, dir string) string {
+	t.Helper()
+	tmp := t.TempDir()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return writeFileErr(filepath.Join(tmp, rel), data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tmp
+}
+
+// checkGolden compares the Go files in the fixed copy of the fixture dir with
+// their golden versions, or with the originals if they have none.
+func checkGolden(t *testing.T, dir, fixed string) {
+	t.Helper()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".go") {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		want, err := os.ReadFile(path + ".golden")
+		if os.IsNotExist(err) {
+			want, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return err
+		}
+		if got := readFile(t, filepath.Join(fixed, rel)); got != string(want) {
+			t.Errorf("%s:\n%s\nwant:\n%s", rel, got, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkBuild checks that the module in dir builds, if the go command is
+// around.
+func checkBuild(t *testing.T, dir string) {
+	t.Helper()
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Log("no go command to build with")
+		return
+	}
+	cmd := exec.Command("go", "build", "./...")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("building the fixed files: %s\n%s", err, out)
+	}
+}
+
+// checkUnchanged checks that the Go files of the fixture dir are the same in
+// its copy copied.
+func checkUnchanged(t *testing.T, dir, copied string) {
+	t.Helper()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".go") {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if got, want := readFile(t, filepath.Join(copied, rel)), readFile(t, path); got != want {
+			t.Errorf("%s changed:\n%s", rel, got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// chdir changes the current directory to dir until the test ends.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.Chdir(wd); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := writeFileErr(path, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeFileErr(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
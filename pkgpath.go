@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"go/build"
+	"go/version"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cabify/gofixunkeyedcomposites/unkeyed"
+)
+
+// importPath returns the import path of the package in dir, looking first for
+// an enclosing module and then for an enclosing GOPATH entry, in fsys. If
+// neither is found, it returns the cleaned dir.
+func importPath(fsys fileSystem, dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return filepath.Clean(dir)
+	}
+	if root, gomod, ok := findModule(fsys, dir); ok {
+		modPath, ok := goModDirective(gomod, "module")
+		rel, err := filepath.Rel(root, dir)
+		if ok && err == nil {
+			return path.Join(modPath, filepath.ToSlash(rel))
+		}
+	}
+	if p, err := buildContext(fsys).ImportDir(dir, build.FindOnly); err == nil && p.ImportPath != "." {
+		return p.ImportPath
+	}
+	return dir
+}
+
+// moduleGoVersion returns the Go language version declared by the go
+// directive of the module enclosing dir in fsys, like go1.21, or the empty
+// string if there's no module or directive.
+func moduleGoVersion(fsys fileSystem, dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	_, gomod, ok := findModule(fsys, dir)
+	if !ok {
+		return ""
+	}
+	v, ok := goModDirective(gomod, "go")
+	if !ok || !version.IsValid("go"+v) {
+		return ""
+	}
+	return "go" + v
+}
+
+// findModule walks up from dir, an absolute path, looking for a go.mod file in
+// fsys, and returns the directory containing it and its contents.
+func findModule(fsys fileSystem, dir string) (root string, gomod []byte, ok bool) {
+	return unkeyed.FindModule(buildContext(fsys), dir)
+}
+
+// goModDirective returns the argument of the first directive named name in
+// the go.mod contents data.
+func goModDirective(data []byte, name string) (string, bool) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == name {
+			return strings.Trim(fields[1], `"`), true
+		}
+	}
+	return "", false
+}
+
+// matchPkg reports whether the import path pkgPath matches any of patterns.
+// A pattern is either an import path or an import path followed by /...,
+// which matches that package and every package below it. The pattern ...
+// matches every package.
+func matchPkg(pkgPath string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "..." {
+			return true
+		}
+		if prefix := strings.TrimSuffix(pattern, "/..."); prefix != pattern {
+			if pkgPath == prefix || strings.HasPrefix(pkgPath, prefix+"/") {
+				return true
+			}
+		} else if pkgPath == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"go/token"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/cabify/gofixunkeyedcomposites/unkeyed"
+)
+
+// A diagnostic is a problem reported by the checking modes.
+type diagnostic struct {
+	pos     token.Position
+	message string
+}
+
+var reportFormats = map[string]func(io.Writer, []diagnostic) error{
+	"text":       writeTextReport,
+	"checkstyle": writeCheckstyleReport,
+	"sarif":      writeSARIFReport,
+}
+
+// writeTextReport writes one path:line:col: message line per diagnostic, as
+// the Go tools do. The format is kept strict, without token.Position's
+// special cases, so that editors can always parse it, like Vim's quickfix
+// list or Emacs' compilation mode.
+func writeTextReport(w io.Writer, diags []diagnostic) error {
+	for _, d := range diags {
+		_, err := fmt.Fprintf(w, "%s:%d:%d: %s\n", d.pos.Filename, d.pos.Line, d.pos.Column, d.message)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type checkstyleReport struct {
+	XMLName xml.Name         `xml:"checkstyle"`
+	Version string           `xml:"version,attr"`
+	Files   []checkstyleFile `xml:"file"`
+}
+
+type checkstyleFile struct {
+	Name   string            `xml:"name,attr"`
+	Errors []checkstyleError `xml:"error"`
+}
+
+type checkstyleError struct {
+	Line     int    `xml:"line,attr"`
+	Column   int    `xml:"column,attr"`
+	Severity string `xml:"severity,attr"`
+	Message  string `xml:"message,attr"`
+	Source   string `xml:"source,attr"`
+}
+
+func writeCheckstyleReport(w io.Writer, diags []diagnostic) error {
+	report := checkstyleReport{Version: "5.0"}
+	files := map[string]int{}
+	for _, d := range diags {
+		i, ok := files[d.pos.Filename]
+		if !ok {
+			i = len(report.Files)
+			files[d.pos.Filename] = i
+			report.Files = append(report.Files, checkstyleFile{Name: d.pos.Filename})
+		}
+		report.Files[i].Errors = append(report.Files[i].Errors, checkstyleError{
+			Line:     d.pos.Line,
+			Column:   d.pos.Column,
+			Severity: "error",
+			Message:  d.message,
+			Source:   "gofixunkeyedcomposites",
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+const sarifRuleID = "unkeyed-composite-literal"
+
+// writeSARIFReport writes a SARIF 2.1.0 log, as consumed for example by
+// GitHub code scanning.
+func writeSARIFReport(w io.Writer, diags []diagnostic) error {
+	type message struct {
+		Text string `json:"text"`
+	}
+	type rule struct {
+		ID               string  `json:"id"`
+		ShortDescription message `json:"shortDescription"`
+	}
+	type region struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn"`
+	}
+	type artifactLocation struct {
+		URI string `json:"uri"`
+	}
+	type physicalLocation struct {
+		ArtifactLocation artifactLocation `json:"artifactLocation"`
+		Region           region           `json:"region"`
+	}
+	type location struct {
+		PhysicalLocation physicalLocation `json:"physicalLocation"`
+	}
+	type result struct {
+		RuleID    string     `json:"ruleId"`
+		Level     string     `json:"level"`
+		Message   message    `json:"message"`
+		Locations []location `json:"locations"`
+	}
+	type driver struct {
+		Name           string `json:"name"`
+		InformationURI string `json:"informationUri"`
+		Rules          []rule `json:"rules"`
+	}
+	type tool struct {
+		Driver driver `json:"driver"`
+	}
+	type run struct {
+		Tool    tool     `json:"tool"`
+		Results []result `json:"results"`
+	}
+	type log struct {
+		Version string `json:"version"`
+		Schema  string `json:"$schema"`
+		Runs    []run  `json:"runs"`
+	}
+
+	results := make([]result, 0, len(diags))
+	for _, d := range diags {
+		results = append(results, result{
+			RuleID:  sarifRuleID,
+			Level:   "error",
+			Message: message{Text: d.message},
+			Locations: []location{{PhysicalLocation: physicalLocation{
+				ArtifactLocation: artifactLocation{URI: filepath.ToSlash(d.pos.Filename)},
+				Region:           region{StartLine: d.pos.Line, StartColumn: d.pos.Column},
+			}}},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs: []run{{
+			Tool: tool{Driver: driver{
+				Name:           "gofixunkeyedcomposites",
+				InformationURI: "https://github.com/cabify/gofixunkeyedcomposites",
+				Rules: []rule{{
+					ID:               sarifRuleID,
+					ShortDescription: message{Text: "struct literal uses unkeyed fields"},
+				}},
+			}},
+			Results: results,
+		}},
+	})
+}
+
+// writeChangesReport writes a JSON array describing lits, the literals keys
+// were added to, to the file name.
+func writeChangesReport(fsys fileSystem, name string, lits []unkeyedLit) error {
+	type change struct {
+		File   string   `json:"file"`
+		Line   int      `json:"line"`
+		Column int      `json:"column"`
+		Type   string   `json:"type"`
+		Fields []string `json:"fields"`
+	}
+	changes := make([]change, 0, len(lits))
+	for _, lit := range lits {
+		changes = append(changes, change{
+			File:   lit.pos.Filename,
+			Line:   lit.pos.Line,
+			Column: lit.pos.Column,
+			Type:   lit.typ,
+			Fields: lit.fields,
+		})
+	}
+	data, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsys.WriteFile(name, append(data, '\n'), 0644)
+}
+
+// writeTypesReport writes the struct types counts has unkeyed literals of,
+// with the counts, most first.
+func writeTypesReport(w io.Writer, counts map[string]int) {
+	typs := make([]string, 0, len(counts))
+	for typ := range counts {
+		typs = append(typs, typ)
+	}
+	sort.Slice(typs, func(i, j int) bool {
+		if counts[typs[i]] != counts[typs[j]] {
+			return counts[typs[i]] > counts[typs[j]]
+		}
+		return typs[i] < typs[j]
+	})
+	for _, typ := range typs {
+		fmt.Fprintf(w, "%d\t%s\n", counts[typ], typ)
+	}
+}
+
+// A runSummary has the totals of a whole run, for -summary-json.
+type runSummary struct {
+	FilesScanned    int                        `json:"filesScanned"`
+	FilesChanged    int                        `json:"filesChanged"`
+	LiteralsKeyed   int                        `json:"literalsKeyed"`
+	LiteralsSkipped map[unkeyed.SkipReason]int `json:"literalsSkipped"`
+	Duration        float64                    `json:"durationSeconds"`
+	Errors          []string                   `json:"errors"`
+}
+
+func writeSummary(fsys fileSystem, name string, summary runSummary) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsys.WriteFile(name, append(data, '\n'), 0644)
+}
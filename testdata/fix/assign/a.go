@@ -0,0 +1,27 @@
+package assign
+
+type T struct{ A, B int }
+
+type U struct{ S string }
+
+var (
+	a = T{1, 2}
+	b = T{3, 4}
+)
+
+var c, d = T{5, 6}, U{"d"}
+
+const n = 2
+
+var arr = [n]T{{7, 8}, {9, 10}}
+
+func f() (T, U) {
+	x, y := T{11, 12}, U{"y"}
+	x, y = T{13, 14}, U{"z"}
+	var (
+		p = T{15, 16}
+		q = U{"q"}
+	)
+	_, _ = p, q
+	return x, y
+}
@@ -0,0 +1,3 @@
+module example.com/assign
+
+go 1.22
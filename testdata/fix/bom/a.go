@@ -0,0 +1,9 @@
+﻿// This file starts with a byte order mark, which gofmt would drop, but
+// fixing it must keep, along with the offsets of keys after it.
+package bom
+
+type T struct{ A, B int }
+
+var t = T{1, 2}
+
+var s = []T{{3, 4}, T{5, 6}}
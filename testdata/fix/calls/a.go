@@ -0,0 +1,16 @@
+package calls
+
+type P struct{ X, Y int }
+
+func variadic(ps ...P) []P { return ps }
+
+func point() P { return P{1, 2} }
+
+func points() []P { return []P{{1, 2}} }
+
+func f(s []P) {
+	s = append(s, P{1, 2}, P{3, 4})
+	s = append(s, variadic(P{5, 6})...)
+	m := make(map[P][]P, len([]P{{7, 8}}))
+	_, _ = s, m
+}
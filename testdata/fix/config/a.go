@@ -0,0 +1,16 @@
+package config
+
+type SubConfig struct{ A, B int }
+
+type Config struct {
+	Sub  SubConfig
+	Name string
+}
+
+var a = Config{Sub: SubConfig{1, 2}}
+
+var b = Config{SubConfig{1, 2}, "b"}
+
+var c = Config{Sub: SubConfig{A: 1, B: 2}, Name: "c"}
+
+var d = []Config{{SubConfig{3, 4}, "d"}, {Sub: SubConfig{5, 6}}}
@@ -0,0 +1,28 @@
+package control
+
+type T struct{ A, B int }
+
+func f(n int, ch chan T) T {
+	switch (T{1, 2}) {
+	case T{1, 2}:
+		return T{3, 4}
+	}
+	switch {
+	case n > 0:
+		ch <- T{5, 6}
+	default:
+		_ = []T{{7, 8}}
+	}
+	select {
+	case ch <- T{9, 10}:
+	case v := <-ch:
+		return T{v.A, 11}
+	default:
+	}
+	var i interface{} = T{12, 13}
+	switch i.(type) {
+	case T:
+		return T{14, 15}
+	}
+	return T{}
+}
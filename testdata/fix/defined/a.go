@@ -0,0 +1,21 @@
+package defined
+
+type B struct{ X, Y int }
+
+type A B
+
+type C A
+
+type Alias = C
+
+type Generic[T any] struct{ V T }
+
+type Inst Generic[A]
+
+var (
+	a = A{1, 2}
+	c = C{3, 4}
+	d = Alias{5, 6}
+	i = Inst{A{7, 8}}
+	s = []C{{9, 10}}
+)
@@ -0,0 +1,21 @@
+package dotimport
+
+import (
+	. "example.com/dotimport/color"
+	. "example.com/dotimport/geo"
+)
+
+type Shape struct {
+	Bounds Rect
+	Fill   RGB
+}
+
+var p = Point{1, 2}
+
+var r = Rect{Point{0, 0}, Point{3, 4}}
+
+var px = Pixel{5, 6, RGB{255, 0, 0}}
+
+var s = Shape{Rect{Point{1, 1}, Point{2, 2}}, RGB{0, 0, 255}}
+
+var ps = []Point{{7, 8}}
@@ -0,0 +1,8 @@
+package color
+
+type RGB struct{ R, G, B uint8 }
+
+type Pixel struct {
+	X, Y  int
+	Color RGB
+}
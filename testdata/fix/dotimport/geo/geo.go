@@ -0,0 +1,5 @@
+package geo
+
+type Point struct{ X, Y int }
+
+type Rect struct{ Min, Max Point }
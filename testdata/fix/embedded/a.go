@@ -0,0 +1,18 @@
+package embedded
+
+import "example.com/embedded/other"
+
+type E struct{ V int }
+
+type W struct{ E }
+
+type M struct {
+	E
+	other.Other
+	*other.Ptr
+}
+
+var (
+	w = W{E{1}}
+	m = M{E{1}, other.Other{2}, &other.Ptr{"s"}}
+)
@@ -0,0 +1,5 @@
+package other
+
+type Other struct{ N int }
+
+type Ptr struct{ S string }
@@ -0,0 +1,19 @@
+package embeddederror
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+type Err struct{ error }
+
+type Reader struct {
+	io.Reader
+	N int
+}
+
+var (
+	e = Err{errors.New("e")}
+	r = Reader{strings.NewReader("r"), 1}
+)
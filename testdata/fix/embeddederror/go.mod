@@ -0,0 +1,3 @@
+module example.com/embeddederror
+
+go 1.22
@@ -0,0 +1,39 @@
+package exprs
+
+import "strings"
+
+type T struct {
+	Sel, Index, Call int
+	Lit              E
+	Sum, Neg         int
+	Ptr              *E
+	Fn               func() int
+	Paren, Conv      int
+	Slice            []int
+	Method           string
+	Assert           int
+}
+
+type E struct{ N int }
+
+var (
+	e      = E{1}
+	xs     = []int{1, 2}
+	x      = 3
+	y      = 4
+	i  any = 5
+)
+
+func d() int { return 6 }
+
+var t = T{
+	e.N, xs[0], d(),
+	E{7},
+	x + y, -x,
+	&E{8},
+	func() int { return 9 },
+	(x), int(y),
+	xs[:1],
+	strings.ToUpper("a"),
+	i.(int),
+}
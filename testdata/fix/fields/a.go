@@ -0,0 +1,41 @@
+package fields
+
+import "fmt"
+
+type ID struct{ v int }
+
+type Any struct {
+	A any
+	E interface{ Error() string }
+}
+
+type Named struct {
+	Type, Func string
+	String     int
+}
+
+func (Named) Str() string { return "" }
+
+type Inner struct{}
+
+func (Inner) Name() string { return "" }
+
+type Shadow struct {
+	Inner
+	Name string
+}
+
+type Raw struct {
+	S string
+	N int
+}
+
+var (
+	id  = ID{5}
+	ka  = ID{v: 5}
+	a   = Any{[]int{1}, fmt.Errorf("%d", 1)}
+	n   = Named{"a", "b", 1}
+	s   = Shadow{Inner{}, "n"}
+	raw = Raw{`a
+	b`, 1}
+)
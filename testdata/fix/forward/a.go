@@ -0,0 +1,14 @@
+package forward
+
+var (
+	early = Later{1, 2}
+	other = Elsewhere{"x", "y"}
+)
+
+func f() {
+	_ = []Later{{3, 4}}
+}
+
+func g() Later { return Later{5, 6} }
+
+type Later struct{ A, B int }
@@ -0,0 +1,4 @@
+package forward
+
+// In another file, which sorts after a.go.
+type Elsewhere struct{ X, Y string }
@@ -0,0 +1,19 @@
+package funcfields
+
+type Handler struct {
+	Name string
+	Fn   func(int) int
+	Done func()
+}
+
+func double(n int) int { return 2 * n }
+
+var a = Handler{"double", double, nil}
+
+var b = Handler{"inline", func(n int) int {
+	return n + 1
+}, func() {}}
+
+var c = []Handler{{"x", func(int) int { return 0 }, func() {
+	_ = Handler{"nested", double, nil}
+}}}
@@ -0,0 +1,3 @@
+module example.com/funcfields
+
+go 1.22
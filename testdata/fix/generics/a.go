@@ -0,0 +1,13 @@
+package generics
+
+type P[E any] struct{ X, Y E }
+
+type Q struct{ X, Y int }
+
+func f[T struct{ X, Y int }]() T { return T{1, 2} }
+
+func g[E any](a, b E) P[E] { return P[E]{a, b} }
+
+func h[E any]() Q { return Q{1, 2} }
+
+var p = P[string]{"a", "b"}
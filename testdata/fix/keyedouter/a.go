@@ -0,0 +1,17 @@
+package keyedouter
+
+type Inner struct{ A, B int }
+
+type Outer struct {
+	In   Inner
+	List []Inner
+	M    map[string]Inner
+	N    int
+}
+
+var o = Outer{
+	In:   Inner{1, 2},
+	List: []Inner{{3, 4}, Inner{5, 6}},
+	M:    map[string]Inner{"x": {7, 8}},
+	N:    9,
+}
@@ -0,0 +1,3 @@
+module example.com/keyedouter
+
+go 1.22
@@ -0,0 +1,13 @@
+package linedirective
+
+type T struct{ A, B int }
+
+//line generated.tmpl:100
+var a = T{1, 2}
+
+/*line other.tmpl:1:1*/
+var b = T{3, 4}
+
+//line :7
+var c = []T{{5, 6},
+	{7, 8}}
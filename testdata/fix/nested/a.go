@@ -0,0 +1,16 @@
+package nested
+
+import "example.com/nested/c"
+
+type B struct {
+	N int
+	D c.D
+}
+
+type A struct{ B B }
+
+var (
+	a = A{B{1, c.D{2, 3}}}
+	s = []A{{B{1, c.D{2, 3}}}}
+	m = [][]c.D{{{1, 2}}, {{3, 4}, c.D{5, 6}}}
+)
@@ -0,0 +1,3 @@
+package c
+
+type D struct{ X, Y int }
@@ -0,0 +1,19 @@
+package newer
+
+import (
+	"cmp"
+	"slices"
+)
+
+type T struct{ A, B int }
+
+func f(ts []T) []T {
+	out := []T{{min(1, 2), max(3, 4, 5)}}
+	for i := range 3 {
+		out = append(out, T{i, i * 2})
+	}
+	slices.SortFunc(ts, func(x, y T) int { return cmp.Compare(x.A, y.A) })
+	m := map[int]T{1: {6, 7}}
+	clear(m)
+	return append(out, ts...)
+}
@@ -0,0 +1,17 @@
+package parens
+
+type T struct{ A, B int }
+
+type Outer struct {
+	In T
+	P  *T
+	N  int
+}
+
+var a = T{(1), (2)}
+
+var b = Outer{(T{1, 2}), (&T{3, 4}), (5)}
+
+var c = []T{{(1 + 2), (3)}}
+
+var d = (T{6, 7})
@@ -0,0 +1,3 @@
+module example.com/parens
+
+go 1.22
@@ -0,0 +1,14 @@
+package pointerfields
+
+type Inner struct{ A, B int }
+
+type Outer struct {
+	F *Inner
+	N int
+}
+
+var (
+	o  = Outer{&Inner{1, 2}, 3}
+	os = []Outer{{&Inner{4, 5}, 6}}
+	po = &Outer{F: &Inner{7, 8}}
+)
@@ -0,0 +1,3 @@
+module example.com/pointerfields
+
+go 1.22
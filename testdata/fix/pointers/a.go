@@ -0,0 +1,13 @@
+package pointers
+
+type P struct{ X, Y int }
+
+type PP = *P
+
+var (
+	p  = &P{1, 2}
+	ps = []*P{{1, 2}, {3, 4}}
+	pm = map[string]*P{"a": {1, 2}}
+	pa = [...]PP{{5, 6}}
+	pk = map[*P]bool{{7, 8}: true}
+)
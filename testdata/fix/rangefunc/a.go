@@ -0,0 +1,28 @@
+package rangefunc
+
+type T struct{ A, B int }
+
+func seq(yield func(T) bool) {
+	if !yield(T{1, 2}) {
+		return
+	}
+	yield(T{3, 4})
+}
+
+func pairs(yield func(int, T) bool) {
+	yield(0, T{5, 6})
+}
+
+func f() []T {
+	var out []T
+	for v := range seq {
+		out = append(out, T{v.A, 7})
+	}
+	for i, v := range pairs {
+		out = append(out, T{i, v.B})
+	}
+	for range 3 {
+		out = append(out, T{8, 9})
+	}
+	return out
+}
@@ -0,0 +1,21 @@
+package shadow
+
+import "example.com/shadow/other"
+
+type W struct {
+	other other.T
+	N     int
+}
+
+func f() W {
+	other := other.T{1, 2}
+	return W{other, 3}
+}
+
+func g() W {
+	return W{other.T{4, 5}, 6}
+}
+
+func h(T other.T) other.T {
+	return other.T{T.B, T.A}
+}
@@ -0,0 +1,3 @@
+package other
+
+type T struct{ A, B int }
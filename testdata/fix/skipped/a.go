@@ -0,0 +1,13 @@
+package skipped
+
+import "example.com/skipped/other"
+
+type P struct{ X, Y int }
+
+var (
+	keyed = P{X: 1, Y: 2}
+	empty = P{}
+	h     = other.Hidden{}
+	arr   = [2]int{1, 2}
+	m     = map[int]int{1: 2}
+)
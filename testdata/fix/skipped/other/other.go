@@ -0,0 +1,3 @@
+package other
+
+type Hidden struct{ x, Y int }
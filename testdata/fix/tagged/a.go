@@ -0,0 +1,5 @@
+package tagged
+
+type Base struct{ X, Y int }
+
+var b = Base{1, 2}
@@ -0,0 +1,5 @@
+//go:build !integration
+
+package tagged
+
+var b3 = Base{5, 6}
@@ -0,0 +1,5 @@
+//go:build integration
+
+package tagged
+
+type Extra struct{ P, Q string }
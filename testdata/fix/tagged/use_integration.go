@@ -0,0 +1,8 @@
+//go:build integration
+
+package tagged
+
+var (
+	e  = Extra{"p", "q"}
+	b2 = Base{3, 4}
+)
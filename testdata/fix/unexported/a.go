@@ -0,0 +1,9 @@
+package unexported
+
+import "example.com/unexported/other"
+
+var (
+	w  = other.Wrap{other.NewInner(1, 2), 3}
+	l  = other.List{{1, 2}, {3, 4}}
+	ws = []other.Wrap{{other.List{{5, 6}}[0], 7}}
+)
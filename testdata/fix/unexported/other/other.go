@@ -0,0 +1,15 @@
+package other
+
+type inner struct{ A, B int }
+
+func NewInner(a, b int) inner { return inner{a, b} }
+
+// Wrap has an exported field of an unexported type.
+type Wrap struct {
+	In inner
+	N  int
+}
+
+// List has elements of an unexported type, so they can only be written
+// with their type elided outside of this package.
+type List []inner
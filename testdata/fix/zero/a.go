@@ -0,0 +1,17 @@
+package zero
+
+type T struct {
+	A int
+	B string
+	C any
+	D *T
+	E []int
+	F float64
+	G bool
+	H rune
+}
+
+var (
+	t = T{0, "", nil, nil, nil, 0.0, false, 0}
+	u = T{0, ``, nil, (*T)(nil), []int{}, 0e0, !true, '\x00'}
+)
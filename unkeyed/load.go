@@ -0,0 +1,448 @@
+package unkeyed
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LoadConfig controls how Load finds, reads and type-checks a file's package.
+type LoadConfig struct {
+	// Context is used to list, select and read the files in the package's
+	// directory; build constraints are evaluated with it, and its GOARCH
+	// determines type sizes. If nil, build.Default is used. Dependencies are
+	// imported from source with it too, but those in modules are found by
+	// the go command, on disk, whatever its file system callbacks.
+	Context *build.Context
+
+	// Overlay maps absolute file names to contents to use instead of the
+	// files' contents on disk. Files in it don't need to exist on disk, in
+	// which case they're added to their directory's files, and the directory
+	// itself doesn't need to exist either.
+	Overlay map[string][]byte
+
+	// GoVersion is the Go language version to type-check with, as for
+	// types.Config. If empty, the toolchain's version is used.
+	GoVersion string
+
+	// PkgPath is the import path of the package, which qualifies the names
+	// of its types. If empty, the package's absolute directory is used.
+	PkgPath string
+}
+
+// A File is a Go file loaded with Load.
+type File struct {
+	Fset *token.FileSet
+	AST  *ast.File
+	// Src is what AST was parsed from. It's read only once, so the
+	// positions in AST always match it even if the file changes on disk
+	// meanwhile, and edits must be applied to it rather than to the file
+	// read again.
+	Src []byte
+	// Files are the files type-checked together, AST included.
+	Files []*ast.File
+	Pkg   *types.Package
+	// Info has its Types and Defs maps populated.
+	Info *types.Info
+	// TypeErrors are the errors found while reading or parsing the other
+	// files in the package, which are then left out, and while
+	// type-checking. They don't prevent fixing, but literals involved in
+	// them may lack type information.
+	TypeErrors []error
+	// ImportErrors maps the paths of the imports that couldn't be resolved
+	// to why, which also shows up as type errors where they're used.
+	ImportErrors map[string]error
+}
+
+// Load parses the Go file filename and type-checks it along with the other
+// files of the same package in its directory. Sibling files are only included
+// if they satisfy the context's build constraints and can be parsed, but
+// filename always is, and errors reading or parsing it are returned.
+//
+// Load can be called concurrently.
+func Load(filename string, conf LoadConfig) (*File, error) {
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(filename, ".go") {
+		return nil, fmt.Errorf("%s: not a Go file within a package", filename)
+	}
+	dir := filepath.Dir(filename)
+	ctxt := overlayContext(conf.Context, conf.Overlay)
+
+	infos, err := ctxt.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	var file *File
+	var parsed []*ast.File
+	var siblingErrs []error
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if path != filename {
+			if ok, err := ctxt.MatchFile(dir, name); !ok && err == nil {
+				continue
+			}
+		}
+
+		src, err := readFile(ctxt, path)
+		if err == nil {
+			var f *ast.File
+			f, err = parser.ParseFile(fset, path, src, parser.ParseComments)
+			if err == nil {
+				if path == filename {
+					file = &File{Fset: fset, AST: f, Src: src}
+				}
+				parsed = append(parsed, f)
+				continue
+			}
+		}
+		if path == filename {
+			return nil, err
+		}
+		// A broken sibling only means less type information.
+		siblingErrs = append(siblingErrs, err)
+	}
+	if file == nil {
+		return nil, fmt.Errorf("%s: no such Go file in %s", filename, dir)
+	}
+
+	file.TypeErrors = siblingErrs
+	for _, f := range parsed {
+		if f.Name.Name == file.AST.Name.Name {
+			file.Files = append(file.Files, f)
+		}
+	}
+
+	imp := newSourceImporter(fset, ctxt, dir)
+	cfg := &types.Config{
+		Error: func(err error) {
+			// Collected, but otherwise not our concern.
+			file.TypeErrors = append(file.TypeErrors, err)
+		},
+		Importer:                 imp,
+		FakeImportC:              true,
+		DisableUnusedImportCheck: true,
+		GoVersion:                conf.GoVersion,
+		Sizes:                    imp.sizes,
+	}
+	file.Info = &types.Info{
+		Types: map[ast.Expr]types.TypeAndValue{},
+		Defs:  map[*ast.Ident]types.Object{},
+	}
+	pkgPath := conf.PkgPath
+	if pkgPath == "" {
+		pkgPath = dir
+	}
+	file.Pkg, _ = cfg.Check(pkgPath, fset, file.Files, file.Info)
+	file.ImportErrors = imp.errs
+
+	return file, nil
+}
+
+// FixFile loads filename like Load and returns its source with keys added and
+// formatted like Format does, and whether any key was added.
+func (o Options) FixFile(filename string, conf LoadConfig) (out []byte, changed bool, err error) {
+	f, err := Load(filename, conf)
+	if err != nil {
+		return nil, false, err
+	}
+	edits, changed := o.FixAST(f.Fset, f.AST, f.Info, f.Src)
+	if !changed {
+		return f.Src, false, nil
+	}
+	out, err = Format(f.Src, edits)
+	return out, true, err
+}
+
+var utf8BOM = []byte("\ufeff")
+
+// Format applies edits to src and formats the result with gofmt.
+func Format(src []byte, edits []Edit) ([]byte, error) {
+	out, err := format.Source(Apply(src, edits))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(src, utf8BOM) {
+		// The parser skips a leading byte order mark while still counting
+		// it in offsets, so edits line up, but formatting drops it. Keep it,
+		// since the rest of the file is unchanged.
+		out = append(append([]byte(nil), utf8BOM...), out...)
+	}
+	return out, nil
+}
+
+// FormatMinimal is like Format, but only formats the top-level declarations
+// of f, parsed from src into fset, that edits apply to. The rest of src is
+// kept as is, so the changes are limited to the edited declarations even if
+// the file wasn't formatted with gofmt. Edits outside any declaration are
+// applied without formatting.
+func FormatMinimal(fset *token.FileSet, f *ast.File, src []byte, edits []Edit) ([]byte, error) {
+	edits = append([]Edit(nil), edits...)
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].Offset < edits[j].Offset
+	})
+	file := fset.File(f.Pos())
+
+	var out bytes.Buffer
+	var offset int
+	for _, decl := range f.Decls {
+		start, end := file.Offset(decl.Pos()), file.Offset(decl.End())
+		var declEdits []Edit
+		for len(edits) > 0 && edits[0].Offset < end {
+			if edits[0].Offset >= start {
+				declEdits = append(declEdits, Edit{Offset: edits[0].Offset - start, Text: edits[0].Text})
+			} else {
+				out.Write(src[offset:edits[0].Offset])
+				out.WriteString(edits[0].Text)
+				offset = edits[0].Offset
+			}
+			edits = edits[1:]
+		}
+		if len(declEdits) == 0 {
+			continue
+		}
+		formatted, err := format.Source(Apply(src[start:end], declEdits))
+		if err != nil {
+			return nil, err
+		}
+		out.Write(src[offset:start])
+		out.Write(formatted)
+		offset = end
+	}
+	Write(&out, src[offset:], shiftEdits(edits, -offset))
+	return out.Bytes(), nil
+}
+
+func shiftEdits(edits []Edit, delta int) []Edit {
+	shifted := make([]Edit, len(edits))
+	for i, edit := range edits {
+		shifted[i] = Edit{Offset: edit.Offset + delta, Text: edit.Text}
+	}
+	return shifted
+}
+
+// overlayContext returns a copy of ctxt, or of build.Default if nil, that
+// reads files and lists directories with overlay on top.
+func overlayContext(ctxt *build.Context, overlay map[string][]byte) *build.Context {
+	var c build.Context
+	if ctxt != nil {
+		c = *ctxt
+	} else {
+		c = build.Default
+	}
+	openFile, readDir := c.OpenFile, c.ReadDir
+
+	cleaned := make(map[string][]byte, len(overlay))
+	for path, src := range overlay {
+		cleaned[filepath.Clean(path)] = src
+	}
+	overlay = cleaned
+
+	c.OpenFile = func(path string) (io.ReadCloser, error) {
+		if src, ok := overlay[filepath.Clean(path)]; ok {
+			return ioutil.NopCloser(bytes.NewReader(src)), nil
+		}
+		if openFile != nil {
+			return openFile(path)
+		}
+		return os.Open(path)
+	}
+
+	c.ReadDir = func(dir string) ([]fs.FileInfo, error) {
+		var infos []fs.FileInfo
+		var err error
+		if readDir != nil {
+			infos, err = readDir(dir)
+		} else {
+			infos, err = ioutil.ReadDir(dir)
+		}
+
+		seen := map[string]bool{}
+		for _, info := range infos {
+			seen[info.Name()] = true
+		}
+		var added bool
+		for path, src := range overlay {
+			if filepath.Dir(path) != filepath.Clean(dir) {
+				continue
+			}
+			added = true
+			if name := filepath.Base(path); !seen[name] {
+				infos = append(infos, overlayFileInfo{name: name, size: int64(len(src))})
+			}
+		}
+		if err != nil && !added {
+			return nil, err
+		}
+		return infos, nil
+	}
+
+	return &c
+}
+
+func readFile(ctxt *build.Context, path string) ([]byte, error) {
+	if ctxt.OpenFile == nil {
+		return os.ReadFile(path)
+	}
+	f, err := ctxt.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// overlayFileInfo describes an overlay file that doesn't exist on disk.
+type overlayFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi overlayFileInfo) Name() string       { return fi.name }
+func (fi overlayFileInfo) Size() int64        { return fi.size }
+func (fi overlayFileInfo) Mode() fs.FileMode  { return 0644 }
+func (fi overlayFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi overlayFileInfo) IsDir() bool        { return false }
+func (fi overlayFileInfo) Sys() interface{}   { return nil }
+
+// FindModule walks up from dir, an absolute path, looking for the go.mod file
+// of the module it's in, read with ctxt, or build.Default if nil, and returns
+// the directory containing it and its contents.
+func FindModule(ctxt *build.Context, dir string) (root string, gomod []byte, ok bool) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	for {
+		if data, err := readFile(ctxt, filepath.Join(dir, "go.mod")); err == nil {
+			return dir, data, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil, false
+		}
+		dir = parent
+	}
+}
+
+// sourceImporter imports packages from source, finding them with ctxt and
+// reading their files with read, the context it's given. For a package in a
+// module, ctxt has no file system callbacks, so that go/build resolves imports
+// with the go command, run in the module's root, which only sees the disk.
+// Outside any module, ctxt keeps read's callbacks, which keep go/build from
+// doing so, and imports are found in GOPATH, as with GO111MODULE=auto, rather
+// than failing for lack of a go.mod. Packages are loaded once per importer, so
+// types from a package are the same wherever it's imported.
+//
+// Packages using cgo are type-checked with the references to C faked, rather
+// than built, so no C toolchain is needed, and errors type-checking
+// dependencies are ignored: only their exported API matters, which those
+// errors rarely affect.
+type sourceImporter struct {
+	fset  *token.FileSet
+	ctxt  *build.Context
+	read  *build.Context
+	sizes types.Sizes
+	// pkgs maps package directories to their packages, or to nil while
+	// they're being imported.
+	pkgs map[string]*types.Package
+	errs map[string]error
+}
+
+func newSourceImporter(fset *token.FileSet, ctxt *build.Context, dir string) *sourceImporter {
+	c := *ctxt
+	if root, _, ok := FindModule(ctxt, dir); ok {
+		c.JoinPath, c.SplitPathList, c.IsAbsPath, c.IsDir = nil, nil, nil, nil
+		c.HasSubdir, c.ReadDir, c.OpenFile = nil, nil, nil
+		c.Dir = root
+	} else if c.JoinPath == nil {
+		c.JoinPath = filepath.Join
+	}
+	return &sourceImporter{
+		fset:  fset,
+		ctxt:  &c,
+		read:  ctxt,
+		sizes: types.SizesFor("gc", c.GOARCH),
+		pkgs:  map[string]*types.Package{},
+	}
+}
+
+func (i *sourceImporter) Import(path string) (*types.Package, error) {
+	return i.ImportFrom(path, "", 0)
+}
+
+func (i *sourceImporter) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	pkg, err := i.importFrom(path, dir)
+	if err != nil {
+		if i.errs == nil {
+			i.errs = map[string]error{}
+		}
+		i.errs[path] = err
+	}
+	return pkg, err
+}
+
+func (i *sourceImporter) importFrom(path, dir string) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+	bp, err := i.ctxt.Import(path, dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	if pkg, ok := i.pkgs[bp.Dir]; ok {
+		if pkg == nil {
+			return nil, fmt.Errorf("import cycle through package %q", bp.ImportPath)
+		}
+		return pkg, nil
+	}
+	i.pkgs[bp.Dir] = nil
+
+	var files []*ast.File
+	for _, names := range [][]string{bp.GoFiles, bp.CgoFiles} {
+		for _, name := range names {
+			path := filepath.Join(bp.Dir, name)
+			src, err := readFile(i.read, path)
+			var f *ast.File
+			if err == nil {
+				f, err = parser.ParseFile(i.fset, path, src, 0)
+			}
+			if err != nil {
+				delete(i.pkgs, bp.Dir)
+				return nil, err
+			}
+			files = append(files, f)
+		}
+	}
+	cfg := &types.Config{
+		Importer:                 i,
+		Error:                    func(error) {},
+		FakeImportC:              true,
+		IgnoreFuncBodies:         true,
+		DisableUnusedImportCheck: true,
+		Sizes:                    i.sizes,
+	}
+	pkg, _ := cfg.Check(bp.ImportPath, i.fset, files, nil)
+	i.pkgs[bp.Dir] = pkg
+	return pkg, nil
+}
@@ -0,0 +1,330 @@
+// Package unkeyed finds struct composite literals with unkeyed fields and
+// computes the edits that add keys to them.
+//
+// It works on already parsed and type-checked files, so it can be embedded in
+// tools that have loaded the package themselves, like go/analysis passes.
+package unkeyed
+
+import (
+	"bytes"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io"
+	"sort"
+)
+
+// An Edit inserts Text at byte offset Offset of a file's source.
+type Edit struct {
+	Offset int
+	Text   string
+}
+
+// A Literal is a composite literal that keys can be added to.
+type Literal struct {
+	Lit *ast.CompositeLit
+	// Type is the literal's type, which is a struct, a defined type or alias
+	// with a struct as underlying type, or a pointer to any of them for
+	// literals with elided &.
+	Type types.Type
+	// Fields are the names of the struct's fields, in order.
+	Fields []string
+	// Edits add the keys, one per field, in field order.
+	Edits []Edit
+}
+
+// Options control which literals get keys added.
+type Options struct {
+	// MinFields is the minimum number of fields a struct must have for its
+	// literals to be keyed.
+	MinFields int
+
+	// OnlyRisky limits keying to literals of structs with at least two
+	// consecutive fields of identical types, whose values are easy to swap by
+	// mistake without the compiler noticing.
+	OnlyRisky bool
+
+	// KeyFormatter returns the text inserted before the element for the
+	// field named fieldName. If nil, it's fieldName followed by ": ".
+	KeyFormatter func(fieldName string) string
+
+	// Warn, if not nil, is called for literals that are left alone even
+	// though they have unkeyed elements, with the literal's position and the
+	// reason. The only such case is a literal mixing keyed and unkeyed
+	// elements, which doesn't compile, but may show up in files excluded by
+	// build constraints.
+	Warn func(pos token.Pos, msg string)
+
+	// Skip, if not nil, is called for literals with unkeyed elements that
+	// don't get keys, with the reason why. Literals known not to be of
+	// struct types aren't included.
+	Skip func(lit *ast.CompositeLit, reason SkipReason)
+}
+
+// A SkipReason is why a literal with unkeyed elements doesn't get keys.
+type SkipReason string
+
+const (
+	// SkipNoType is for literals without type information, as happens in
+	// erroneous programs or when imports can't be resolved.
+	SkipNoType SkipReason = "no-type"
+	// SkipMinFields is for structs with fewer fields than MinFields.
+	SkipMinFields SkipReason = "min-fields"
+	// SkipNotRisky is for structs without adjacent fields of the same type,
+	// with OnlyRisky.
+	SkipNotRisky SkipReason = "not-risky"
+	// SkipMixed is for literals mixing keyed and unkeyed elements.
+	SkipMixed SkipReason = "mixed"
+	// SkipMissingFields is for literals with fewer elements than fields.
+	SkipMissingFields SkipReason = "missing-fields"
+	// SkipUnexported is for structs with unexported fields from another
+	// package, which can't be named.
+	SkipUnexported SkipReason = "unexported-field"
+	// SkipBlankField is for structs with blank fields, which can't be named.
+	SkipBlankField SkipReason = "blank-field"
+)
+
+func (o Options) formatKey(fieldName string) string {
+	if o.KeyFormatter != nil {
+		return o.KeyFormatter(fieldName)
+	}
+	return fieldName + ": "
+}
+
+// FixAST is like Options.FixAST with the default options.
+func FixAST(fset *token.FileSet, f *ast.File, info *types.Info, src []byte) (edits []Edit, changed bool) {
+	return Options{}.FixAST(fset, f, info, src)
+}
+
+// FixAST returns the edits that add keys to the unkeyed struct literals in f,
+// as parsed from src into fset and type-checked into info, and whether there
+// are any. info must have at least its Types and Defs maps populated.
+//
+// The edits can be applied to src with Apply.
+func (o Options) FixAST(fset *token.FileSet, f *ast.File, info *types.Info, src []byte) (edits []Edit, changed bool) {
+	for _, lit := range o.Literals(fset, f, info) {
+		edits = append(edits, lit.Edits...)
+	}
+	return edits, len(edits) > 0
+}
+
+// Literals returns the unkeyed struct literals in f that keys can be added
+// to, in source order.
+func (o Options) Literals(fset *token.FileSet, f *ast.File, info *types.Info) []Literal {
+	v := &visitor{
+		file:  fset.File(f.Pos()),
+		pkg:   filePackage(f, info),
+		types: info.Types,
+		opts:  o,
+	}
+	ast.Walk(v, f)
+	return v.lits
+}
+
+// Apply returns a copy of src with the edits applied.
+//
+// Edits at the same offset are applied in the order given, so their texts end
+// up in that order too. Edits from Literals and FixAST come in walk order,
+// which puts the keys of an outer literal before those of literals nested in
+// it.
+func Apply(src []byte, edits []Edit) []byte {
+	var out bytes.Buffer
+	out.Grow(len(src) + editsLen(edits))
+	Write(&out, src, edits) // Writing to a bytes.Buffer never fails.
+	return out.Bytes()
+}
+
+// Write is like Apply, but writes the result to w as it goes instead of
+// building it in memory.
+func Write(w io.Writer, src []byte, edits []Edit) error {
+	edits = append([]Edit(nil), edits...)
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].Offset < edits[j].Offset
+	})
+
+	var offset int
+	for _, edit := range edits {
+		if _, err := w.Write(src[offset:edit.Offset]); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, edit.Text); err != nil {
+			return err
+		}
+		offset = edit.Offset
+	}
+	_, err := w.Write(src[offset:])
+	return err
+}
+
+// Spaced returns a copy of edits, meant for src, with a space added before
+// the keys that would otherwise directly follow a comma, as in T{1,2}. That
+// keeps the result tidy when it isn't formatted afterwards.
+func Spaced(src []byte, edits []Edit) []Edit {
+	spaced := make([]Edit, len(edits))
+	for i, edit := range edits {
+		if edit.Offset > 0 && src[edit.Offset-1] == ',' {
+			edit.Text = " " + edit.Text
+		}
+		spaced[i] = edit
+	}
+	return spaced
+}
+
+func editsLen(edits []Edit) int {
+	var n int
+	for _, edit := range edits {
+		n += len(edit.Text)
+	}
+	return n
+}
+
+// filePackage returns the package f was type-checked into, as found through
+// the objects it declares.
+func filePackage(f *ast.File, info *types.Info) *types.Package {
+	var pkg *types.Package
+	ast.Inspect(f, func(n ast.Node) bool {
+		if pkg != nil {
+			return false
+		}
+		if id, ok := n.(*ast.Ident); ok {
+			if obj := info.Defs[id]; obj != nil {
+				pkg = obj.Pkg()
+			}
+		}
+		return true
+	})
+	return pkg
+}
+
+type visitor struct {
+	file  *token.File
+	pkg   *types.Package
+	types map[ast.Expr]types.TypeAndValue
+	opts  Options
+
+	lits []Literal
+}
+
+func (v *visitor) Visit(node ast.Node) ast.Visitor {
+	lit, ok := node.(*ast.CompositeLit)
+	if !ok {
+		return v
+	}
+
+	keyed := countKeyed(lit)
+	if keyed == len(lit.Elts) {
+		// Already has keys, or no elements; nothing to add.
+		return v
+	}
+
+	typ, ok := v.types[lit]
+	if !ok || typ.Type == nil || typ.Type == types.Typ[types.Invalid] {
+		// No type information, as can happen with erroneous programs.
+		switch lit.Type.(type) {
+		case *ast.ArrayType, *ast.MapType:
+			// Not a struct anyway.
+		default:
+			v.skip(lit, SkipNoType)
+		}
+		return v
+	}
+	s, ok := assertStructType(typ.Type)
+	if !ok {
+		return v
+	}
+
+	if s.NumFields() == 0 {
+		// Empty struct; no keys to add.
+		return v
+	}
+	if s.NumFields() < v.opts.MinFields {
+		// Small enough to be left positional.
+		v.skip(lit, SkipMinFields)
+		return v
+	}
+	if v.opts.OnlyRisky && !hasAdjacentSameType(s) {
+		// No adjacent fields of the same type to mix up.
+		v.skip(lit, SkipNotRisky)
+		return v
+	}
+	if keyed > 0 {
+		if v.opts.Warn != nil {
+			v.opts.Warn(lit.Pos(), "struct literal mixes keyed and unkeyed fields; not adding keys")
+		}
+		v.skip(lit, SkipMixed)
+		return v
+	}
+	if len(lit.Elts) != s.NumFields() {
+		// Missing fields; nothing to add.
+		v.skip(lit, SkipMissingFields)
+		return v
+	}
+
+	for i := 0; i < s.NumFields(); i++ {
+		if f := s.Field(i); !f.Exported() && f.Pkg() != v.pkg {
+			// Unexported field from another package; it can't be named
+			// here, so keying would produce invalid code.
+			v.skip(lit, SkipUnexported)
+			return v
+		}
+		if s.Field(i).Name() == "_" {
+			// Blank fields can only be given positionally.
+			v.skip(lit, SkipBlankField)
+			return v
+		}
+	}
+
+	fields := make([]string, 0, s.NumFields())
+	edits := make([]Edit, 0, s.NumFields())
+	for i := 0; i < s.NumFields(); i++ {
+		fields = append(fields, s.Field(i).Name())
+		edits = append(edits, Edit{
+			Offset: v.file.Offset(lit.Elts[i].Pos()),
+			Text:   v.opts.formatKey(s.Field(i).Name()),
+		})
+	}
+	v.lits = append(v.lits, Literal{Lit: lit, Type: typ.Type, Fields: fields, Edits: edits})
+
+	return v
+}
+
+func (v *visitor) skip(lit *ast.CompositeLit, reason SkipReason) {
+	if v.opts.Skip != nil {
+		v.opts.Skip(lit, reason)
+	}
+}
+
+func countKeyed(lit *ast.CompositeLit) int {
+	var n int
+	for _, elt := range lit.Elts {
+		if _, ok := elt.(*ast.KeyValueExpr); ok {
+			n++
+		}
+	}
+	return n
+}
+
+// hasAdjacentSameType reports whether s has two consecutive fields of
+// identical types.
+func hasAdjacentSameType(s *types.Struct) bool {
+	for i := 1; i < s.NumFields(); i++ {
+		if types.Identical(s.Field(i-1).Type(), s.Field(i).Type()) {
+			return true
+		}
+	}
+	return false
+}
+
+func assertStructType(typ types.Type) (*types.Struct, bool) {
+	// Aliases, generic or not, may stand for the pointer type of a literal
+	// with elided &.
+	if p, ok := types.Unalias(typ).(*types.Pointer); ok {
+		typ = p.Elem()
+	}
+	// Underlying sees through aliases and defined types, instantiated or not.
+	// For type parameters it's their constraint, an interface, so literals
+	// typed with them are left alone even where the constraint only allows
+	// structs; literals of concrete types in generic code are still fixed.
+	s, ok := typ.Underlying().(*types.Struct)
+	return s, ok
+}
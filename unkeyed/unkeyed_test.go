@@ -0,0 +1,103 @@
+package unkeyed
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"testing"
+)
+
+// allocBudget is how many allocations finding and keying the literals of
+// the fields fixture may take. It took 37 when the budget was set, and the
+// rest leaves room for toolchain changes. Raise it, on purpose, only if a
+// change needs the allocations.
+const allocBudget = 60
+
+// TestAllocs guards the hot path, walking the AST for literals and applying
+// the edits that key them, against allocation regressions.
+func TestAllocs(t *testing.T) {
+	t.Setenv("GO111MODULE", "on")
+	t.Setenv("GOFLAGS", "")
+	f, err := Load(filepath.Join("..", "testdata", "fix", "fields", "a.go"), LoadConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.TypeErrors) > 0 {
+		t.Fatal(f.TypeErrors)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		edits, _ := FixAST(f.Fset, f.AST, f.Info, f.Src)
+		Apply(f.Src, edits)
+	})
+	if allocs > allocBudget {
+		t.Errorf("%v allocations to fix the fixture, over the budget of %d", allocs, allocBudget)
+	}
+}
+
+// TestNilType checks that literals without type information, either not in
+// info.Types or recorded there with a nil type, are skipped as such rather
+// than keyed or crashed on.
+func TestNilType(t *testing.T) {
+	const src = "package x\n\nvar (\n\ta = P{1, 2}\n\tb = P{3, 4}\n\tc = []int{5, 6}\n)\n"
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "x.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{
+		Types: map[ast.Expr]types.TypeAndValue{},
+		Defs:  map[*ast.Ident]types.Object{},
+	}
+	var recorded bool
+	ast.Inspect(f, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.CompositeLit); ok && !recorded {
+			info.Types[lit] = types.TypeAndValue{}
+			recorded = true
+		}
+		return true
+	})
+
+	skips := map[SkipReason]int{}
+	opts := Options{Skip: func(lit *ast.CompositeLit, reason SkipReason) { skips[reason]++ }}
+	if edits, changed := opts.FixAST(fset, f, info, []byte(src)); changed {
+		t.Errorf("edits: %v", edits)
+	}
+	if skips[SkipNoType] != 2 || len(skips) != 1 {
+		t.Errorf("skipped: %v, want both struct literals for lack of type", skips)
+	}
+}
+
+// TestApplySameOffset checks that edits at the same offset are applied in the
+// order given, however many there are and wherever the other edits are. With
+// an unstable sort, the order of coincident edits could vary.
+func TestApplySameOffset(t *testing.T) {
+	const src = "T{x, y}"
+	var edits, reversed []Edit
+	want := "T{"
+	for i := 0; i < 20; i++ {
+		text := string(rune('a'+i)) + ": "
+		edits = append(edits, Edit{Offset: 2, Text: text})
+		want += text
+	}
+	want += "x, Y: y}"
+	// Around an edit elsewhere, given first, to make sorting move things.
+	edits = append([]Edit{{Offset: 5, Text: "Y: "}}, edits...)
+	for i := len(edits) - 1; i >= 1; i-- {
+		reversed = append(reversed, edits[i])
+	}
+	reversed = append(reversed, edits[0])
+
+	if got := string(Apply([]byte(src), edits)); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	wantReversed := "T{"
+	for i := 19; i >= 0; i-- {
+		wantReversed += string(rune('a'+i)) + ": "
+	}
+	wantReversed += "x, Y: y}"
+	if got := string(Apply([]byte(src), reversed)); got != wantReversed {
+		t.Errorf("reversed: got %q, want %q", got, wantReversed)
+	}
+}